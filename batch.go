@@ -1,25 +1,36 @@
 package format
 
 import (
+	"container/list"
 	"context"
 	"errors"
+	"fmt"
 	"runtime"
+	"slices"
+	"sync"
+	"time"
 
 	cid "github.com/ipfs/go-cid"
 )
 
-// parallelBatchCommits is the number of batch commits that can be in-flight before blocking.
+// parallelCommits is the default number of batch commits that can be
+// in-flight before blocking (see ParallelCommitsBatchOption).
 // TODO(ipfs/go-ipfs#4299): Experiment with multiple datastores, storage
 // devices, and CPUs to find the right value/formula.
 var parallelCommits = runtime.NumCPU()
 
 // ErrNotCommited is returned when closing a batch that hasn't been successfully
-// committed.
+// committed (see Batch.Close).
 var ErrNotCommited = errors.New("error: batch not commited")
 
-// ErrClosed is returned when operating on a batch that has already been closed.
+// ErrClosed is returned when operating on a batch that has already been closed
+// (see Batch.Close and Batch.Abort).
 var ErrClosed = errors.New("error: batch closed")
 
+// ErrRemoveNotSupported is returned when removing nodes through a batch
+// whose NodeAdder is not a NodeRemover.
+var ErrRemoveNotSupported = errors.New("error: batch can't remove nodes")
+
 // NewBatch returns a node buffer (Batch) that buffers nodes internally and
 // commits them to the underlying DAGService in batches. Use this if you intend
 // to add or remove a lot of nodes all at once.
@@ -28,83 +39,390 @@ var ErrClosed = errors.New("error: batch closed")
 func NewBatch(ctx context.Context, na NodeAdder, opts ...BatchOption) *Batch {
 	ctx, cancel := context.WithCancel(ctx)
 	bopts := defaultBatchOptions
+	bopts.parallelism = parallelCommits
 	for _, o := range opts {
 		o(&bopts)
 	}
+	if bopts.parallelism < 1 {
+		bopts.parallelism = 1
+	}
 
-	// Commit numCPU batches at once, but split the maximum buffer size over all commits in flight.
-	bopts.maxSize /= parallelCommits
-	bopts.maxNodes /= parallelCommits
-	return &Batch{
-		na:            na,
-		ctx:           ctx,
-		cancel:        cancel,
-		commitResults: make(chan error, parallelCommits),
-		opts:          bopts,
+	// Commit many batches at once, but split the maximum buffer size over
+	// all commits in flight. Ordered batches only have one in flight.
+	if !bopts.ordered {
+		bopts.maxSize = splitLimit(bopts.maxSize, bopts.parallelism)
+		bopts.maxNodes = splitLimit(bopts.maxNodes, bopts.parallelism)
+	}
+	b := &Batch{
+		na:      na,
+		ctx:     ctx,
+		cancel:  cancel,
+		changed: make(chan struct{}),
+		index:   make(map[cid.Cid]*batchEntry),
+		opts:    bopts,
 	}
+	if bopts.dedupCache > 0 {
+		b.recent = newCidLRU(bopts.dedupCache)
+	}
+	return b
 }
 
-// Batch is a buffer for batching adds to a dag.
+// splitLimit splits a buffer limit over n commits, keeping positive limits at
+// one or more so a small limit doesn't turn into a commit per operation.
+func splitLimit(limit, n int) int {
+	if limit <= 0 {
+		return limit
+	}
+	return max(limit/n, 1)
+}
+
+// Batch is a buffer for batching adds and removals to a dag.
+//
+// A Batch is safe for concurrent use: many goroutines can add and remove
+// nodes at the same time (e.g., chunking files in parallel), and a Commit
+// from any of them waits for the operations all goroutines batched before
+// it started.
 type Batch struct {
+	// Guards all the fields below. It is released while waiting for
+	// commits to finish so other goroutines can keep batching.
+	mu sync.Mutex
+
 	na NodeAdder
 
 	ctx    context.Context
 	cancel func()
 
-	activeCommits int
-	err           error
-	commitResults chan error
+	// Commits in flight, in the order they were issued.
+	inflight []*batchCommit
+	err      error
 
-	nodes []Node
-	size  int
+	// Closed (and replaced) every time a commit finishes or the batch
+	// fails, to wake up the goroutines waiting on them.
+	changed chan struct{}
+
+	nodes    []Node
+	keys     []cid.Cid // the CIDs of `nodes`
+	removals []cid.Cid
+	size     int
+
+	// The operations buffered and in flight, by CID.
+	index map[cid.Cid]*batchEntry
+
+	// Fires after `opts.flushInterval` without new operations.
+	flushTimer *time.Timer
+
+	stats BatchStats
+
+	// CIDs of the latest committed nodes (see DedupCacheBatchOption).
+	recent *cidLRU
+
+	// Set once Close started, after which no operations are accepted.
+	closing bool
+
+	// Operations that were not (or not known to be) committed when the
+	// batch failed.
+	uncommitted []BatchOperation
 
 	opts batchOptions
 }
 
-func (t *Batch) processResults() {
-	for t.activeCommits > 0 {
-		select {
-		case err := <-t.commitResults:
-			t.activeCommits--
-			if err != nil {
-				t.setError(err)
-				return
-			}
-		default:
-			return
+// BatchOperation is an operation batched in a Batch: the addition of Node,
+// or the removal of the node with the CID Removed when Node is nil.
+type BatchOperation struct {
+	Node    Node
+	Removed cid.Cid
+}
+
+// BatchStats reports the state of a Batch.
+type BatchStats struct {
+	// Operations buffered and not yet sent to the NodeAdder.
+	PendingNodes    int
+	PendingRemovals int
+	PendingBytes    int
+
+	// Number of commits in flight.
+	ActiveCommits int
+
+	// Totals of the successfully finished commits.
+	Commits           int
+	CommittedNodes    int
+	CommittedRemovals int
+	CommittedBytes    int
+
+	// Number of nodes dropped by DedupBatchOption.
+	Deduplicated int
+}
+
+// BatchCommitInfo describes a finished commit of a Batch.
+type BatchCommitInfo struct {
+	Nodes    int
+	Removals int
+	Bytes    int
+	Duration time.Duration
+
+	// Number of times the commit was tried (see RetryBatchOption).
+	Attempts int
+
+	// Err is the error returned by the NodeAdder, if any, as an
+	// ErrBatchCommit.
+	Err error
+}
+
+// batchCommit is a set of operations committed to the NodeAdder at once.
+// A commit never both adds and removes the same node.
+type batchCommit struct {
+	nodes    []Node
+	keys     []cid.Cid // the CIDs of `nodes`
+	removals []cid.Cid
+	size     int
+	err      error
+}
+
+// batchEntry tracks the operations on a CID buffered or in flight in a
+// Batch, so the latest one can be found without going through them all.
+type batchEntry struct {
+	// node is the node added by the latest operation, or nil if it was a
+	// removal.
+	node Node
+
+	bufferedAdds     int
+	bufferedRemoval  bool
+	inflightAdds     int
+	inflightRemovals int
+}
+
+// unused returns whether there are no operations left on the entry.
+func (e *batchEntry) unused() bool {
+	return e.bufferedAdds == 0 && !e.bufferedRemoval && e.inflightAdds == 0 && e.inflightRemovals == 0
+}
+
+// run commits the operations to the NodeAdder, retrying them as configured,
+// and stores the outcome in `err`.
+func (c *batchCommit) run(ctx context.Context, na NodeAdder, opts batchOptions) {
+	start := time.Now()
+	attempts := 0
+	if len(c.nodes) > 0 {
+		n, err := opts.retry.do(ctx, func() error {
+			return na.AddMany(ctx, c.nodes)
+		})
+		attempts += n
+		if err != nil {
+			c.err = newBatchCommitError(c.keys, c.removals, err)
+		}
+	}
+	if c.err == nil && len(c.removals) > 0 {
+		n, err := opts.retry.do(ctx, func() error {
+			return na.(NodeRemover).RemoveMany(ctx, c.removals)
+		})
+		attempts += n
+		if err != nil {
+			c.err = newBatchCommitError(nil, c.removals, err)
 		}
 	}
+	if opts.onCommit != nil {
+		opts.onCommit(BatchCommitInfo{
+			Nodes:    len(c.nodes),
+			Removals: len(c.removals),
+			Bytes:    c.size,
+			Attempts: attempts,
+			Duration: time.Since(start),
+			Err:      c.err,
+		})
+	}
+}
+
+// wait releases the lock until a commit finishes, the batch fails or the
+// given context is done, returning the error of the batch or the context
+// in the latter cases. Callers must re-check the state of the batch
+// afterwards as other goroutines may have modified it.
+func (t *Batch) wait(ctx context.Context) error {
+	changed, bctx := t.changed, t.ctx
+	t.mu.Unlock()
+	select {
+	case <-changed:
+	case <-bctx.Done():
+	case <-ctx.Done():
+	}
+	t.mu.Lock()
+
+	if t.err == nil && bctx.Err() != nil {
+		t.setError(bctx.Err())
+	}
+	if t.err != nil {
+		return t.err
+	}
+	return ctx.Err()
 }
 
-func (t *Batch) asyncCommit() {
-	numBlocks := len(t.nodes)
-	if numBlocks == 0 {
+// notify wakes up all the goroutines in wait.
+func (t *Batch) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// finishCommit processes a commit once it has run. A failed commit fails
+// the whole batch.
+func (t *Batch) finishCommit(c *batchCommit) {
+	if t.err != nil {
+		// Already accounted for by setError.
 		return
 	}
-	if t.activeCommits >= parallelCommits {
-		select {
-		case err := <-t.commitResults:
-			t.activeCommits--
+	if c.err != nil {
+		// Leave it in flight for setError to account for its operations.
+		t.setError(c.err)
+		return
+	}
+	if i := slices.Index(t.inflight, c); i >= 0 {
+		t.inflight = slices.Delete(t.inflight, i, i+1)
+	}
+	for _, k := range c.keys {
+		e := t.index[k]
+		e.inflightAdds--
+		t.release(k, e)
+	}
+	for _, r := range c.removals {
+		e := t.index[r]
+		e.inflightRemovals--
+		t.release(r, e)
+	}
+	if t.recent != nil {
+		for _, k := range c.keys {
+			t.recent.add(k)
+		}
+		for _, r := range c.removals {
+			t.recent.remove(r)
+		}
+	}
+	t.stats.Commits++
+	t.stats.CommittedNodes += len(c.nodes)
+	t.stats.CommittedRemovals += len(c.removals)
+	t.stats.CommittedBytes += c.size
+	t.notify()
+}
 
-			if err != nil {
-				t.setError(err)
-				return
+// get returns the latest version of the node with the CID c known to the
+// batch: the node itself if it is being added, or ErrNotFound if it is being
+// removed, either in the buffer or in a commit in flight. It returns
+// (nil, nil) if there are no pending operations on c.
+func (t *Batch) get(c cid.Cid) (Node, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return nil, t.err
+	}
+	return t.lookup(c)
+}
+
+// lookup implements get, the lock must be held.
+func (t *Batch) lookup(c cid.Cid) (Node, error) {
+	e, ok := t.index[c]
+	switch {
+	case !ok:
+		return nil, nil
+	case e.node == nil:
+		return nil, ErrNotFound{Cid: c}
+	default:
+		return e.node, nil
+	}
+}
+
+// entry returns the index entry for the CID c, creating it if needed.
+func (t *Batch) entry(c cid.Cid) *batchEntry {
+	e, ok := t.index[c]
+	if !ok {
+		e = new(batchEntry)
+		t.index[c] = e
+	}
+	return e
+}
+
+// release drops the index entry e for the CID c once it is unused.
+func (t *Batch) release(c cid.Cid, e *batchEntry) {
+	if e.unused() {
+		delete(t.index, c)
+	}
+}
+
+// asyncCommit sends the buffered operations to the NodeAdder in a new
+// commit, waiting for a free slot first if needed. If the context is done
+// before that, the operations stay buffered and the context error is
+// returned.
+func (t *Batch) asyncCommit(ctx context.Context) error {
+	for {
+		if t.err != nil {
+			return t.err
+		}
+		if len(t.nodes) == 0 && len(t.removals) == 0 {
+			return nil
+		}
+
+		// Operations can't run in parallel with a commit doing the opposite
+		// on the same node, or it could be applied last. With
+		// OrderedBatchOption no commits run in parallel at all.
+		exclusive := t.opts.ordered || t.conflicts()
+		if (exclusive && len(t.inflight) > 0) || len(t.inflight) >= t.opts.parallelism {
+			if err := t.wait(ctx); err != nil {
+				return err
 			}
-		case <-t.ctx.Done():
-			t.setError(t.ctx.Err())
-			return
+			continue
 		}
+		break
 	}
-	go func(ctx context.Context, b []Node, result chan error, na NodeAdder) {
-		select {
-		case result <- na.AddMany(ctx, b):
-		case <-ctx.Done():
-		}
-	}(t.ctx, t.nodes, t.commitResults, t.na)
 
-	t.activeCommits++
-	t.nodes = make([]Node, 0, numBlocks)
+	c := &batchCommit{nodes: t.nodes, keys: t.keys, removals: t.removals, size: t.size}
+	for _, k := range c.keys {
+		e := t.index[k]
+		e.bufferedAdds--
+		e.inflightAdds++
+	}
+	for _, r := range c.removals {
+		e := t.index[r]
+		e.bufferedRemoval = false
+		e.inflightRemovals++
+	}
+	go func(ctx context.Context, c *batchCommit, na NodeAdder, opts batchOptions) {
+		c.run(ctx, na, opts)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.finishCommit(c)
+	}(t.ctx, c, t.na, t.opts)
+
+	t.inflight = append(t.inflight, c)
+	t.nodes = make([]Node, 0, len(t.nodes))
+	t.keys = make([]cid.Cid, 0, len(t.keys))
+	t.removals = nil
 	t.size = 0
+	return nil
+}
+
+// conflicts returns whether a buffered operation does the opposite of an
+// in-flight one on the same node, the lock must be held.
+func (t *Batch) conflicts() bool {
+	if len(t.inflight) == 0 {
+		return false
+	}
+	for _, k := range t.keys {
+		if t.index[k].inflightRemovals > 0 {
+			return true
+		}
+	}
+	for _, r := range t.removals {
+		if t.index[r].inflightAdds > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// newBatchCommitError wraps the error of a failed commit of the nodes with
+// the given CIDs and removals.
+func newBatchCommitError(keys []cid.Cid, removals []cid.Cid, err error) error {
+	failed := make([]cid.Cid, 0, len(keys)+len(removals))
+	failed = append(failed, keys...)
+	failed = append(failed, removals...)
+	return ErrBatchCommit{Failed: failed, Cause: err}
 }
 
 // Add adds a node to the batch and commits the batch if necessary.
@@ -114,76 +432,322 @@ func (t *Batch) Add(ctx context.Context, nd Node) error {
 
 // AddMany many calls Add for every given Node, thus batching and
 // commiting them as needed.
+//
+// The context bounds how long it blocks waiting for a commit slot when the
+// batch is full; if it is done first the nodes stay batched and an
+// ErrBatchPending wrapping the context error is returned. The commits
+// themselves use the batch context.
+//
+// Adding a node whose removal is buffered cancels the removal. With
+// DedupBatchOption, nodes already being added are dropped.
 func (t *Batch) AddMany(ctx context.Context, nodes []Node) error {
-	if t.err != nil {
-		return t.err
-	}
-	// Not strictly necessary but allows us to catch errors early.
-	t.processResults()
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
 	if t.err != nil {
 		return t.err
 	}
+	if t.closing {
+		return ErrClosed
+	}
 
-	t.nodes = append(t.nodes, nodes...)
 	for _, nd := range nodes {
+		c := nd.Cid()
+		if t.opts.dedup && t.isDuplicate(c) {
+			t.stats.Deduplicated++
+			continue
+		}
+		e := t.entry(c)
+		if e.bufferedRemoval {
+			t.removals = slices.DeleteFunc(t.removals, c.Equals)
+			e.bufferedRemoval = false
+		}
+		e.node = nd
+		e.bufferedAdds++
+		t.nodes = append(t.nodes, nd)
+		t.keys = append(t.keys, c)
 		t.size += len(nd.RawData())
 	}
 
-	if t.size > t.opts.maxSize || len(t.nodes) > t.opts.maxNodes {
-		t.asyncCommit()
+	return t.batched(t.maybeCommit(ctx))
+}
+
+// isDuplicate returns whether the node with the CID c is already being
+// added by the batch or, with DedupCacheBatchOption, was recently committed
+// (and not removed since).
+func (t *Batch) isDuplicate(c cid.Cid) bool {
+	nd, err := t.lookup(c)
+	switch {
+	case nd != nil:
+		return true
+	case err != nil:
+		// Being removed.
+		return false
 	}
-	return t.err
+	return t.recent != nil && t.recent.contains(c)
 }
 
-// Commit commits batched nodes.
-func (t *Batch) Commit() error {
+// Remove removes a node from the dag, batching it with other additions and
+// removals. The underlying NodeAdder must also be a NodeRemover.
+func (t *Batch) Remove(ctx context.Context, c cid.Cid) error {
+	return t.RemoveMany(ctx, []cid.Cid{c})
+}
+
+// RemoveMany calls Remove for every given CID, thus batching and commiting
+// them as needed. The underlying NodeAdder must also be a NodeRemover. The
+// context is used as in AddMany.
+//
+// Removing a node whose addition is buffered drops the addition. Commits
+// removing or adding a node only run once the in-flight commits doing the
+// opposite on it are done, so the latest operation on a node always wins;
+// others run in parallel.
+func (t *Batch) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	if t.err != nil {
 		return t.err
 	}
+	if t.closing {
+		return ErrClosed
+	}
+	if _, ok := t.na.(NodeRemover); !ok {
+		return ErrRemoveNotSupported
+	}
 
-	t.asyncCommit()
+	for _, c := range cids {
+		e := t.entry(c)
+		if e.bufferedAdds > 0 {
+			t.dropBuffered(c)
+			e.bufferedAdds = 0
+		}
+		e.node = nil
+		if !e.bufferedRemoval {
+			t.removals = append(t.removals, c)
+			e.bufferedRemoval = true
+		}
+	}
 
-loop:
-	for t.activeCommits > 0 {
-		select {
-		case err := <-t.commitResults:
-			t.activeCommits--
-			if err != nil {
-				t.setError(err)
-				break loop
-			}
-		case <-t.ctx.Done():
-			t.setError(t.ctx.Err())
-			break loop
+	return t.batched(t.maybeCommit(ctx))
+}
+
+// dropBuffered drops the buffered additions of the node with the CID c.
+func (t *Batch) dropBuffered(c cid.Cid) {
+	j := 0
+	for i, k := range t.keys {
+		if k.Equals(c) {
+			t.size -= len(t.nodes[i].RawData())
+			continue
+		}
+		t.nodes[j], t.keys[j] = t.nodes[i], k
+		j++
+	}
+	clear(t.nodes[j:])
+	t.nodes, t.keys = t.nodes[:j], t.keys[:j]
+}
+
+// batched returns the error of an operation that was batched, which is an
+// ErrBatchPending unless the batch failed.
+func (t *Batch) batched(err error) error {
+	if err != nil && t.err == nil {
+		return ErrBatchPending{Cause: err}
+	}
+	return err
+}
+
+// maybeCommit commits the buffered operations if they exceed the batch
+// limits, or arms the flush timer otherwise.
+func (t *Batch) maybeCommit(ctx context.Context) error {
+	if t.size > t.opts.maxSize || len(t.nodes)+len(t.removals) > t.opts.maxNodes {
+		return t.asyncCommit(ctx)
+	}
+	if t.opts.flushInterval <= 0 || (len(t.nodes) == 0 && len(t.removals) == 0) {
+		return nil
+	}
+	if t.flushTimer == nil {
+		t.flushTimer = time.AfterFunc(t.opts.flushInterval, t.flush)
+	} else {
+		t.flushTimer.Reset(t.opts.flushInterval)
+	}
+	return nil
+}
+
+// flush commits the buffered operations once the flush interval elapsed.
+func (t *Batch) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.asyncCommit(context.Background())
+}
+
+// Commit commits batched nodes, waiting for all the operations batched
+// before it was called (from any goroutine) to be committed. Operations
+// batched concurrently are not waited for. If adding the nodes to the
+// underlying NodeAdder fails, the error returned is an ErrBatchCommit.
+func (t *Batch) Commit() error {
+	return t.CommitContext(context.Background())
+}
+
+// CommitContext is like Commit but stops waiting when the given context is
+// done, returning its error. The batch is left intact in that case: the
+// commits in flight continue in the background and the operations that
+// could not be sent yet stay batched.
+func (t *Batch) CommitContext(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.commit(ctx)
+}
+
+// commit implements CommitContext, the lock must be held.
+func (t *Batch) commit(ctx context.Context) error {
+	if err := t.asyncCommit(ctx); err != nil {
+		return err
+	}
+
+	issued := slices.Clone(t.inflight)
+	for slices.ContainsFunc(issued, t.isInflight) {
+		if err := t.wait(ctx); err != nil {
+			return err
 		}
 	}
 
 	return t.err
 }
 
+// Close commits all the batched operations and closes the batch. Operations
+// on it return ErrClosed as soon as Close is called, so concurrent producers
+// can't hold it. If the batch had failed or the final commit fails, the
+// error returned wraps both ErrNotCommited and the cause: the operations
+// that could not be committed are available through Uncommitted.
+func (t *Batch) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err == ErrClosed || t.closing {
+		return ErrClosed
+	}
+
+	// Nothing can be batched anymore, so a single commit covers it all.
+	t.closing = true
+	err := t.commit(context.Background())
+	if t.err == nil {
+		t.setError(ErrClosed)
+	}
+	t.err = ErrClosed
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotCommited, err)
+	}
+	return nil
+}
+
+// Abort closes the batch without committing it, canceling the commits in
+// flight. It returns the operations that were dropped as a result (see
+// Uncommitted), after which all operations on the batch return ErrClosed.
+func (t *Batch) Abort() []BatchOperation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err == nil {
+		t.setError(ErrClosed)
+	}
+	t.err = ErrClosed
+	return t.uncommitted
+}
+
+// isInflight returns whether the commit c has not finished yet.
+func (t *Batch) isInflight(c *batchCommit) bool {
+	return slices.Contains(t.inflight, c)
+}
+
+// Uncommitted returns, once the batch has failed, the operations it can't
+// guarantee were durably committed: those of the failed commit, of the
+// commits that were in flight and the ones still buffered. They are ordered
+// so that replaying them (see Replay) in a new batch resumes the work with
+// the latest operation on every node winning. It returns nothing if the
+// batch has not failed.
+func (t *Batch) Uncommitted() []BatchOperation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.uncommitted
+}
+
+// Replay batches the given operations in order, as returned by Uncommitted
+// or Abort, grouping consecutive additions and removals in AddMany and
+// RemoveMany calls.
+func (t *Batch) Replay(ctx context.Context, ops []BatchOperation) error {
+	for len(ops) > 0 {
+		var err error
+		if ops[0].Node != nil {
+			var nodes []Node
+			for len(ops) > 0 && ops[0].Node != nil {
+				nodes = append(nodes, ops[0].Node)
+				ops = ops[1:]
+			}
+			err = t.AddMany(ctx, nodes)
+		} else {
+			var cids []cid.Cid
+			for len(ops) > 0 && ops[0].Node == nil {
+				cids = append(cids, ops[0].Removed)
+				ops = ops[1:]
+			}
+			err = t.RemoveMany(ctx, cids)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the current statistics of the batch.
+func (t *Batch) Stats() BatchStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := t.stats
+	stats.PendingNodes = len(t.nodes)
+	stats.PendingRemovals = len(t.removals)
+	stats.PendingBytes = t.size
+	stats.ActiveCommits = len(t.inflight)
+	return stats
+}
+
 func (t *Batch) setError(err error) {
 	t.err = err
 
 	t.cancel()
+	if t.flushTimer != nil {
+		t.flushTimer.Stop()
+	}
 
-	// Drain as much as we can without blocking.
-loop:
-	for {
-		select {
-		case <-t.commitResults:
-		default:
-			break loop
+	// Keep the operations that were not confirmed as committed around
+	// for Uncommitted, commit by commit in the order they were issued.
+	// Commits adding and removing the same node are never in flight
+	// together, and no commit does both, so the order of adds and removals
+	// within one doesn't matter.
+	pending := append(slices.Clone(t.inflight), &batchCommit{nodes: t.nodes, removals: t.removals})
+	for _, c := range pending {
+		for _, nd := range c.nodes {
+			t.uncommitted = append(t.uncommitted, BatchOperation{Node: nd})
+		}
+		for _, r := range c.removals {
+			t.uncommitted = append(t.uncommitted, BatchOperation{Removed: r})
 		}
 	}
 
+	t.notify()
+
 	// Be nice and cleanup. These can take a *lot* of memory.
-	t.commitResults = nil
 	t.na = nil
 	t.ctx = nil
 	t.nodes = nil
+	t.keys = nil
+	t.removals = nil
 	t.size = 0
-	t.activeCommits = 0
+	t.inflight = nil
+	t.index = nil
 }
 
 // BatchOption provides a way of setting internal options of
@@ -194,8 +758,15 @@ loop:
 type BatchOption func(o *batchOptions)
 
 type batchOptions struct {
-	maxSize  int
-	maxNodes int
+	maxSize       int
+	maxNodes      int
+	parallelism   int
+	flushInterval time.Duration
+	onCommit      func(BatchCommitInfo)
+	retry         BatchRetryPolicy
+	dedup         bool
+	dedupCache    int
+	ordered       bool
 }
 
 var defaultBatchOptions = batchOptions{
@@ -208,7 +779,10 @@ var defaultBatchOptions = batchOptions{
 }
 
 // MaxSizeBatchOption sets the maximum amount of buffered data before writing
-// blocks.
+// blocks. The limit applies to all the commits in flight: each of them is
+// issued once the buffered data exceeds the limit divided by the number of
+// parallel commits (see ParallelCommitsBatchOption), but never less than one
+// byte for a positive limit. A limit of zero commits every operation.
 func MaxSizeBatchOption(size int) BatchOption {
 	return func(o *batchOptions) {
 		o.maxSize = size
@@ -216,16 +790,182 @@ func MaxSizeBatchOption(size int) BatchOption {
 }
 
 // MaxNodesBatchOption sets the maximum number of buffered nodes before writing
-// blocks.
+// blocks. Like MaxSizeBatchOption, the limit is split over the number of
+// parallel commits, but a positive limit always lets one node or more be
+// buffered per commit. A limit of zero commits every operation.
 func MaxNodesBatchOption(num int) BatchOption {
 	return func(o *batchOptions) {
 		o.maxNodes = num
 	}
 }
 
+// ParallelCommitsBatchOption sets the number of commits that can be in
+// flight before operations on the batch block. It defaults to the number of
+// CPUs.
+func ParallelCommitsBatchOption(num int) BatchOption {
+	return func(o *batchOptions) {
+		o.parallelism = num
+	}
+}
+
+// FlushIntervalBatchOption makes the batch commit its buffered operations
+// after the given interval passes without any new ones, instead of keeping
+// them until the size limits are reached or Commit is called.
+func FlushIntervalBatchOption(interval time.Duration) BatchOption {
+	return func(o *batchOptions) {
+		o.flushInterval = interval
+	}
+}
+
+// CommitCallbackBatchOption sets a function called every time a commit of
+// the batch finishes, successfully or not. It is called from the goroutine
+// performing the commit and may be called concurrently.
+func CommitCallbackBatchOption(cb func(BatchCommitInfo)) BatchOption {
+	return func(o *batchOptions) {
+		o.onCommit = cb
+	}
+}
+
+// BatchRetryPolicy configures how failed batch commits are retried.
+type BatchRetryPolicy struct {
+	// Attempts is the maximum number of times a commit is tried,
+	// including the first one. Values lower than two disable retries.
+	Attempts int
+
+	// Backoff is the time waited before the first retry, doubling for
+	// each of the following ones up to MaxBackoff (if set).
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable decides whether a failed commit should be retried given
+	// the error returned by the NodeAdder. By default, all the errors
+	// that are not permanent (see IsPermanent) are retried.
+	Retryable func(error) bool
+}
+
+// do calls fn until it succeeds, the attempts are exhausted, the error is
+// not retryable or the context is canceled, returning the number of
+// attempts made and the last error.
+func (p BatchRetryPolicy) do(ctx context.Context, fn func() error) (int, error) {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = func(err error) bool { return !IsPermanent(err) }
+	}
+
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !retryable(err) {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		}
+
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// RetryBatchOption makes the batch retry failed commits following the given
+// policy before failing. Nodes that could not be committed can be recovered
+// with Uncommitted.
+func RetryBatchOption(policy BatchRetryPolicy) BatchOption {
+	return func(o *batchOptions) {
+		o.retry = policy
+	}
+}
+
+// DedupBatchOption makes the batch drop the nodes added while the same node
+// is still buffered or being committed, instead of passing every copy to the
+// NodeAdder. The number of dropped nodes is reported in BatchStats.
+func DedupBatchOption() BatchOption {
+	return func(o *batchOptions) {
+		o.dedup = true
+	}
+}
+
+// DedupCacheBatchOption enables DedupBatchOption and additionally makes the
+// batch remember the CIDs of up to `size` of the most recently committed
+// nodes, dropping new additions of those as well.
+func DedupCacheBatchOption(size int) BatchOption {
+	return func(o *batchOptions) {
+		o.dedup = true
+		o.dedupCache = size
+	}
+}
+
+// OrderedBatchOption makes the batch wait for every commit to complete
+// before issuing the next one, so nodes are durably written in the order
+// they were added. Adding children before their parents then guarantees no
+// parent is persisted before the nodes it links to (within a single commit,
+// the nodes are passed to AddMany in the order they were added).
+//
+// Operations can still be batched while a commit is in flight, but commits
+// no longer run in parallel, so the limits set by MaxSizeBatchOption and
+// MaxNodesBatchOption apply to each commit as a whole.
+func OrderedBatchOption() BatchOption {
+	return func(o *batchOptions) {
+		o.ordered = true
+	}
+}
+
+// cidLRU is a set of CIDs bounded in size that evicts the least recently
+// used ones.
+type cidLRU struct {
+	size  int
+	order *list.List // of cid.Cid, most recent first
+	items map[cid.Cid]*list.Element
+}
+
+func newCidLRU(size int) *cidLRU {
+	return &cidLRU{
+		size:  size,
+		order: list.New(),
+		items: make(map[cid.Cid]*list.Element),
+	}
+}
+
+func (l *cidLRU) add(c cid.Cid) {
+	if e, ok := l.items[c]; ok {
+		l.order.MoveToFront(e)
+		return
+	}
+	l.items[c] = l.order.PushFront(c)
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(cid.Cid))
+	}
+}
+
+func (l *cidLRU) contains(c cid.Cid) bool {
+	e, ok := l.items[c]
+	if ok {
+		l.order.MoveToFront(e)
+	}
+	return ok
+}
+
+func (l *cidLRU) remove(c cid.Cid) {
+	if e, ok := l.items[c]; ok {
+		l.order.Remove(e)
+		delete(l.items, c)
+	}
+}
+
 // BufferedDAG implements DAGService using a Batch NodeAdder to wrap add
-// operations in the given DAGService. It will trigger Commit() before any
-// non-Add operations, but otherwise calling Commit() is left to the user.
+// and remove operations in the given DAGService. Reads are served from the
+// nodes buffered or being committed by the Batch first, falling through to
+// the DAGService for the rest, so they observe all previous writes without
+// forcing a commit. Calling Commit() is left to the user.
 type BufferedDAG struct {
 	ds DAGService
 	b  *Batch
@@ -245,6 +985,11 @@ func (bd *BufferedDAG) Commit() error {
 	return bd.b.Commit()
 }
 
+// CommitContext calls CommitContext on the Batch.
+func (bd *BufferedDAG) CommitContext(ctx context.Context) error {
+	return bd.b.CommitContext(ctx)
+}
+
 // Add adds a new node using Batch.
 func (bd *BufferedDAG) Add(ctx context.Context, n Node) error {
 	return bd.b.Add(ctx, n)
@@ -255,44 +1000,59 @@ func (bd *BufferedDAG) AddMany(ctx context.Context, nds []Node) error {
 	return bd.b.AddMany(ctx, nds)
 }
 
-// Get commits and gets a node from the DAGService.
+// Get gets a node from the Batch, or from the DAGService if the Batch has no
+// pending operations on it.
 func (bd *BufferedDAG) Get(ctx context.Context, c cid.Cid) (Node, error) {
-	err := bd.b.Commit()
-	if err != nil {
-		return nil, err
+	nd, err := bd.b.get(c)
+	if nd != nil || err != nil {
+		return nd, err
 	}
 	return bd.ds.Get(ctx, c)
 }
 
-// GetMany commits and gets nodes from the DAGService.
+// GetMany gets nodes from the Batch, and from the DAGService those the Batch
+// has no pending operations on. Every NodeOption returned reports the CID it
+// refers to (see GetManyKeyed).
 func (bd *BufferedDAG) GetMany(ctx context.Context, cs []cid.Cid) <-chan *NodeOption {
-	err := bd.b.Commit()
-	if err != nil {
-		ch := make(chan *NodeOption, 1)
-		defer close(ch)
-		ch <- &NodeOption{
-			Node: nil,
+	out := make(chan *NodeOption, len(cs))
+
+	var misses []cid.Cid
+	for _, c := range cs {
+		nd, err := bd.b.get(c)
+		if nd == nil && err == nil {
+			misses = append(misses, c)
+			continue
+		}
+		out <- &NodeOption{
+			Node: nd,
 			Err:  err,
+			Cid:  c,
 		}
-		return ch
 	}
-	return bd.ds.GetMany(ctx, cs)
+	if len(misses) == 0 {
+		close(out)
+		return out
+	}
+
+	go func() {
+		defer close(out)
+		for opt := range GetManyKeyed(ctx, bd.ds, misses) {
+			select {
+			case out <- opt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
 }
 
-// Remove commits and removes a node from the DAGService.
+// Remove removes a node using Batch.
 func (bd *BufferedDAG) Remove(ctx context.Context, c cid.Cid) error {
-	err := bd.b.Commit()
-	if err != nil {
-		return err
-	}
-	return bd.ds.Remove(ctx, c)
+	return bd.b.Remove(ctx, c)
 }
 
-// RemoveMany commits and removes nodes from the DAGService.
+// RemoveMany removes many nodes using Batch.
 func (bd *BufferedDAG) RemoveMany(ctx context.Context, cs []cid.Cid) error {
-	err := bd.b.Commit()
-	if err != nil {
-		return err
-	}
-	return bd.ds.RemoveMany(ctx, cs)
+	return bd.b.RemoveMany(ctx, cs)
 }
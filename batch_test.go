@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"sync"
 	"testing"
+	"time"
 
 	cid "github.com/ipfs/go-cid"
 )
@@ -145,6 +147,16 @@ func TestBatchOptions(t *testing.T) {
 	if b.opts.maxNodes != wantMaxNodes/parallelCommits {
 		t.Fatalf("maxNodes incorrect, want: %d, got: %d", wantMaxNodes, b.opts.maxNodes)
 	}
+
+	// Small limits still buffer at least one node per commit.
+	b = NewBatch(ctx, d, MaxNodesBatchOption(4), ParallelCommitsBatchOption(8))
+	if b.opts.maxNodes != 1 {
+		t.Fatalf("expected maxNodes to be clamped to 1, got %d", b.opts.maxNodes)
+	}
+	b = NewBatch(ctx, d, MaxNodesBatchOption(0), ParallelCommitsBatchOption(8))
+	if b.opts.maxNodes != 0 {
+		t.Fatalf("expected a zero maxNodes to be kept, got %d", b.opts.maxNodes)
+	}
 }
 
 func TestErrorTypes(t *testing.T) {
@@ -165,3 +177,862 @@ func TestErrorTypes(t *testing.T) {
 		t.Fatal("should be an ErrNotFound")
 	}
 }
+
+// failingAdder is a NodeAdder whose AddMany always fails.
+type failingAdder struct {
+	err error
+}
+
+func (a failingAdder) Add(ctx context.Context, nd Node) error {
+	return a.err
+}
+
+func (a failingAdder) AddMany(ctx context.Context, nds []Node) error {
+	return a.err
+}
+
+func TestBatchCommitError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errStorage := errors.New("storage failure")
+	b := NewBatch(ctx, failingAdder{errStorage})
+	nd := new(EmptyNode)
+	if err := b.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	err := b.Commit()
+	var commitErr ErrBatchCommit
+	if !errors.As(err, &commitErr) {
+		t.Fatalf("expected ErrBatchCommit, got %v", err)
+	}
+	if !errors.Is(err, errStorage) {
+		t.Fatalf("expected the commit error to wrap the cause, got %v", err)
+	}
+	if len(commitErr.Failed) != 1 || !commitErr.Failed[0].Equals(nd.Cid()) {
+		t.Fatalf("unexpected failed CIDs: %v", commitErr.Failed)
+	}
+	if IsPermanent(err) {
+		t.Fatal("a storage failure should not be permanent")
+	}
+}
+
+func TestBatchRemove(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newTestDag()
+	existing := InitNode([]byte("existing"))
+	if err := d.Add(ctx, existing); err != nil {
+		t.Fatal(err)
+	}
+	added := InitNode([]byte("added"))
+	removed := InitNode([]byte("removed"))
+	readded := InitNode([]byte("readded"))
+
+	b := NewBatch(ctx, d)
+	for _, op := range []func() error{
+		func() error { return b.Remove(ctx, existing.Cid()) },
+		func() error { return b.Add(ctx, added) },
+		func() error { return b.Add(ctx, removed) },
+		func() error { return b.Remove(ctx, removed.Cid()) },
+		func() error { return b.Remove(ctx, readded.Cid()) },
+		func() error { return b.Add(ctx, readded) },
+	} {
+		if err := op(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(b.nodes) != 2 || len(b.removals) != 2 {
+		t.Fatalf("expected 2 buffered adds and removals, got %d and %d", len(b.nodes), len(b.removals))
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []Node{added, readded} {
+		if _, err := d.Get(ctx, n.Cid()); err != nil {
+			t.Fatalf("expected %s to be added: %s", n, err)
+		}
+	}
+	for _, n := range []Node{existing, removed} {
+		if _, err := d.Get(ctx, n.Cid()); !IsNotFound(err) {
+			t.Fatalf("expected %s to be removed, got %v", n, err)
+		}
+	}
+}
+
+func TestBatchRemoveOrdering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newTestDag()
+	nd := InitNode([]byte("node"))
+
+	// Force a commit on every operation so adds and removals of the same
+	// node end up in different commits.
+	b := NewBatch(ctx, d, MaxNodesBatchOption(0))
+	for i := 0; i < 100; i++ {
+		if err := b.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Remove(ctx, nd.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, nd.Cid()); err != nil {
+		t.Fatalf("expected the last add to win: %s", err)
+	}
+}
+
+func TestBatchParallelRemovals(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, other := InitNode([]byte("a")), InitNode([]byte("other"))
+	d := &blockingDag{testDag: newTestDag(), release: make(chan struct{})}
+	if err := d.testDag.Add(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	removed := make(chan struct{}, 2)
+	b := NewBatch(ctx, d, MaxNodesBatchOption(0), ParallelCommitsBatchOption(4),
+		CommitCallbackBatchOption(func(info BatchCommitInfo) {
+			if info.Removals > 0 && info.Err == nil {
+				removed <- struct{}{}
+			}
+		}))
+
+	// The addition of `a` blocks in flight, which doesn't hold the removal
+	// of an unrelated node.
+	if err := b.Add(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove(ctx, other.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-removed:
+	case <-time.After(time.Second):
+		t.Fatal("the removal should not wait for the addition")
+	}
+
+	// Removing `a` has to wait for it to be added.
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer waitCancel()
+	if err := b.Remove(waitCtx, a.Cid()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the removal to wait, got %v", err)
+	}
+
+	close(d.release)
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, a.Cid()); !IsNotFound(err) {
+		t.Fatalf("expected the removal to be applied last, got %v", err)
+	}
+}
+
+func TestBatchRemoveNotSupported(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := NewBatch(ctx, failingAdder{})
+	if err := b.Remove(ctx, new(EmptyNode).Cid()); err != ErrRemoveNotSupported {
+		t.Fatalf("expected ErrRemoveNotSupported, got %v", err)
+	}
+}
+
+func TestBufferedDAGReadYourWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := newTestDag()
+	stored := InitNode([]byte("stored"))
+	if err := ds.Add(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	bdag := NewBufferedDAG(ctx, ds, MaxNodesBatchOption(1000*parallelCommits))
+
+	buffered := InitNode([]byte("buffered"))
+	if err := bdag.Add(ctx, buffered); err != nil {
+		t.Fatal(err)
+	}
+	if nd, err := bdag.Get(ctx, buffered.Cid()); err != nil || nd != buffered {
+		t.Fatalf("expected to read the buffered node, got %v, %v", nd, err)
+	}
+	if err := bdag.Remove(ctx, stored.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bdag.Get(ctx, stored.Cid()); !IsNotFound(err) {
+		t.Fatalf("expected the removed node to not be found, got %v", err)
+	}
+	if len(ds.nodes) != 1 {
+		t.Fatal("nothing should have been committed yet")
+	}
+
+	other := InitNode([]byte("other"))
+	if err := ds.Add(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[cid.Cid]*NodeOption)
+	for opt := range bdag.GetMany(ctx, []cid.Cid{buffered.Cid(), stored.Cid(), other.Cid()}) {
+		got[opt.Cid] = opt
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(got))
+	}
+	if got[buffered.Cid()].Node != buffered || got[other.Cid()].Err != nil {
+		t.Fatal("expected to get the buffered and stored nodes")
+	}
+	if !IsNotFound(got[stored.Cid()].Err) {
+		t.Fatalf("expected the removed node to not be found, got %v", got[stored.Cid()].Err)
+	}
+
+	if err := bdag.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Get(ctx, buffered.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Get(ctx, stored.Cid()); !IsNotFound(err) {
+		t.Fatalf("expected the node to be removed, got %v", err)
+	}
+}
+
+func TestBatchStatsAndCallbacks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var infos []BatchCommitInfo
+	d := newTestDag()
+	b := NewBatch(ctx, d,
+		ParallelCommitsBatchOption(2),
+		MaxNodesBatchOption(4),
+		CommitCallbackBatchOption(func(info BatchCommitInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			infos = append(infos, info)
+		}),
+	)
+	if b.opts.maxNodes != 2 {
+		t.Fatalf("expected maxNodes to be split over 2 commits, got %d", b.opts.maxNodes)
+	}
+
+	nodes := []Node{InitNode([]byte("1")), InitNode([]byte("22")), InitNode([]byte("333"))}
+	if err := b.AddMany(ctx, nodes); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove(ctx, InitNode([]byte("4444")).Cid()); err != nil {
+		t.Fatal(err)
+	}
+	stats := b.Stats()
+	if stats.PendingNodes != 0 || stats.PendingRemovals != 1 {
+		t.Fatalf("unexpected pending operations: %+v", stats)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats = b.Stats()
+	want := BatchStats{
+		Commits:           2,
+		CommittedNodes:    3,
+		CommittedRemovals: 1,
+		CommittedBytes:    6,
+	}
+	if stats != want {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// The commits don't overlap so they may finish in any order.
+	slices.SortFunc(infos, func(a, b BatchCommitInfo) int { return a.Removals - b.Removals })
+	if len(infos) != 2 || infos[0].Nodes != 3 || infos[0].Bytes != 6 || infos[1].Removals != 1 {
+		t.Fatalf("unexpected commit callbacks: %+v", infos)
+	}
+}
+
+func TestBatchFlushInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newTestDag()
+	b := NewBatch(ctx, d, FlushIntervalBatchOption(10*time.Millisecond))
+	nd := InitNode([]byte("flushed"))
+	if err := b.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := d.Get(ctx, nd.Cid()); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the node was never flushed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := b.Stats(); stats.Commits != 1 || stats.CommittedNodes != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+// flakyDag is a testDag whose AddMany fails the first `failures` times.
+type flakyDag struct {
+	*testDag
+	failures int
+	err      error
+}
+
+func (d *flakyDag) AddMany(ctx context.Context, nodes []Node) error {
+	d.mu.Lock()
+	if d.failures > 0 {
+		d.failures--
+		d.mu.Unlock()
+		return d.err
+	}
+	d.mu.Unlock()
+	return d.testDag.AddMany(ctx, nodes)
+}
+
+func TestBatchRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var attempts int
+	d := &flakyDag{testDag: newTestDag(), failures: 2, err: errors.New("transient")}
+	b := NewBatch(ctx, d,
+		RetryBatchOption(BatchRetryPolicy{Attempts: 3, Backoff: time.Millisecond}),
+		CommitCallbackBatchOption(func(info BatchCommitInfo) {
+			attempts = info.Attempts
+		}),
+	)
+	nd := InitNode([]byte("retried"))
+	if err := b.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+	if _, err := d.Get(ctx, nd.Cid()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchUncommitted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errPermanent := ErrDecode{Cause: errors.New("bad node")}
+	d := &flakyDag{testDag: newTestDag(), failures: 1, err: errPermanent}
+	b := NewBatch(ctx, d, ParallelCommitsBatchOption(1), RetryBatchOption(BatchRetryPolicy{Attempts: 3}))
+
+	nodes := []Node{InitNode([]byte("1")), InitNode([]byte("2"))}
+	if err := b.AddMany(ctx, nodes); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Remove(ctx, InitNode([]byte("3")).Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(); !errors.Is(err, errPermanent) {
+		t.Fatalf("expected the permanent error to not be retried, got %v", err)
+	}
+
+	uncommitted := b.Uncommitted()
+	if len(uncommitted) != 3 || uncommitted[0].Node != nodes[0] || uncommitted[1].Node != nodes[1] ||
+		uncommitted[2].Node != nil || !uncommitted[2].Removed.Equals(InitNode([]byte("3")).Cid()) {
+		t.Fatalf("unexpected uncommitted operations: %v", uncommitted)
+	}
+
+	// Resume with a new batch.
+	b = NewBatch(ctx, d)
+	if err := b.Replay(ctx, uncommitted); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.nodes) != 2 {
+		t.Fatal("expected the uncommitted nodes to be added")
+	}
+}
+
+// failingRemover is a testDag whose removals fail with err once released.
+type failingRemover struct {
+	*testDag
+	release chan struct{}
+	err     error
+}
+
+func (d *failingRemover) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	<-d.release
+	return d.err
+}
+
+func TestBatchUncommittedOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nd := InitNode([]byte("node"))
+	errStorage := errors.New("storage failure")
+	d := &failingRemover{testDag: newTestDag(), release: make(chan struct{}), err: errStorage}
+	b := NewBatch(ctx, d, MaxNodesBatchOption(0))
+
+	// The node is removed in flight and added back while buffered.
+	if err := b.Remove(ctx, nd.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer waitCancel()
+	if err := b.Add(waitCtx, nd); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the addition to wait for the removal, got %v", err)
+	}
+	close(d.release)
+	if err := b.Commit(); !errors.Is(err, errStorage) {
+		t.Fatalf("expected the removal to fail, got %v", err)
+	}
+
+	ops := b.Uncommitted()
+	if len(ops) != 2 || ops[0].Node != nil || !ops[0].Removed.Equals(nd.Cid()) || ops[1].Node != nd {
+		t.Fatalf("expected the removal then the addition, got %v", ops)
+	}
+
+	ds := newTestDag()
+	b = NewBatch(ctx, ds)
+	if err := b.Replay(ctx, ops); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Get(ctx, nd.Cid()); err != nil {
+		t.Fatalf("expected the addition to win, got %v", err)
+	}
+}
+
+// countingDag is a testDag counting the nodes it is asked to add.
+type countingDag struct {
+	*testDag
+	added int
+}
+
+func (d *countingDag) AddMany(ctx context.Context, nodes []Node) error {
+	d.mu.Lock()
+	d.added += len(nodes)
+	d.mu.Unlock()
+	return d.testDag.AddMany(ctx, nodes)
+}
+
+func TestBatchDedup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := InitNode([]byte("a")), InitNode([]byte("b"))
+
+	d := &countingDag{testDag: newTestDag()}
+	batch := NewBatch(ctx, d, DedupBatchOption())
+	for i := 0; i < 10; i++ {
+		if err := batch.AddMany(ctx, []Node{a, b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if d.added != 2 || batch.Stats().Deduplicated != 18 {
+		t.Fatalf("expected 2 nodes added and 18 dropped, got %d and %d", d.added, batch.Stats().Deduplicated)
+	}
+
+	// Without a cache, nodes are added again once committed.
+	if err := batch.Add(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if d.added != 3 {
+		t.Fatalf("expected 3 nodes added, got %d", d.added)
+	}
+
+	d = &countingDag{testDag: newTestDag()}
+	batch = NewBatch(ctx, d, DedupCacheBatchOption(1))
+	for _, nd := range []Node{a, a, b, b, a} {
+		if err := batch.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The cache only holds `b` by the time `a` is added again.
+	if d.added != 3 || batch.Stats().Deduplicated != 2 {
+		t.Fatalf("expected 3 nodes added and 2 dropped, got %d and %d", d.added, batch.Stats().Deduplicated)
+	}
+
+	// Removing a node makes it addable again.
+	if err := batch.Remove(ctx, a.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Add(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, a.Cid()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// cidCountingNode is a TestNode counting the calls to Cid.
+type cidCountingNode struct {
+	*TestNode
+	calls *int
+}
+
+func (n cidCountingNode) Cid() cid.Cid {
+	*n.calls++
+	return n.TestNode.Cid()
+}
+
+func TestBatchIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const count = 1000
+	d := newTestDag()
+	b := NewBatch(ctx, d, DedupBatchOption(), MaxNodesBatchOption(count+1))
+
+	var calls int
+	nodes := make([]Node, count)
+	for i := range nodes {
+		nodes[i] = cidCountingNode{InitNode([]byte(fmt.Sprint(i))), &calls}
+		if err := b.Add(ctx, nodes[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Finding duplicates doesn't go through the buffered nodes.
+	if calls != count {
+		t.Fatalf("expected %d calls to Cid, got %d", count, calls)
+	}
+	if err := b.Remove(ctx, nodes[0].Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if nd, err := b.get(nodes[1].Cid()); err != nil || nd != nodes[1] {
+		t.Fatalf("expected to find the buffered node, got %v, %v", nd, err)
+	}
+	if _, err := b.get(nodes[0].Cid()); !IsNotFound(err) {
+		t.Fatalf("expected the removed node to not be found, got %v", err)
+	}
+
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.index) != 0 {
+		t.Fatalf("expected the index to be empty once committed, got %d entries", len(b.index))
+	}
+	if len(d.nodes) != count-1 {
+		t.Fatalf("expected %d nodes committed, got %d", count-1, len(d.nodes))
+	}
+}
+
+func TestBatchConcurrentAdds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newTestDag()
+	b := NewBatch(ctx, d, ParallelCommitsBatchOption(2), MaxNodesBatchOption(8))
+
+	const producers, perProducer = 8, 100
+	var wg sync.WaitGroup
+	errs := make(chan error, producers)
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				nd := InitNode([]byte(fmt.Sprintf("%d-%d", p, i)))
+				if err := b.Add(ctx, nd); err != nil {
+					errs <- err
+					return
+				}
+				if i%10 == 0 {
+					if err := b.Commit(); err != nil {
+						errs <- err
+						return
+					}
+					// The barrier covers at least this producer's adds.
+					if _, err := d.Get(ctx, nd.Cid()); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.nodes) != producers*perProducer {
+		t.Fatalf("expected %d nodes, got %d", producers*perProducer, len(d.nodes))
+	}
+	if stats := b.Stats(); stats.CommittedNodes != producers*perProducer || stats.ActiveCommits != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+// orderDag is a testDag recording the order nodes are added in and the
+// maximum number of concurrent AddMany calls.
+type orderDag struct {
+	*testDag
+	order                   []Node
+	concurrent, maxParallel int
+}
+
+func (d *orderDag) AddMany(ctx context.Context, nodes []Node) error {
+	d.mu.Lock()
+	d.concurrent++
+	d.maxParallel = max(d.maxParallel, d.concurrent)
+	d.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	d.mu.Lock()
+	d.concurrent--
+	d.order = append(d.order, nodes...)
+	d.mu.Unlock()
+	return d.testDag.AddMany(ctx, nodes)
+}
+
+func TestBatchOrdered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &orderDag{testDag: newTestDag()}
+	b := NewBatch(ctx, d, OrderedBatchOption(), ParallelCommitsBatchOption(4), MaxNodesBatchOption(8))
+	var nodes []Node
+	for i := 0; i < 100; i++ {
+		nd := InitNode([]byte(fmt.Sprint(i)))
+		nodes = append(nodes, nd)
+		if err := b.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.maxParallel != 1 {
+		t.Fatalf("expected commits to run one at a time, got %d in parallel", d.maxParallel)
+	}
+	if b.opts.maxNodes != 8 {
+		t.Fatalf("expected maxNodes to not be split, got %d", b.opts.maxNodes)
+	}
+	if len(d.order) != len(nodes) {
+		t.Fatalf("expected %d nodes, got %d", len(nodes), len(d.order))
+	}
+	for i := range nodes {
+		if d.order[i] != nodes[i] {
+			t.Fatalf("node %d added out of order", i)
+		}
+	}
+}
+
+// blockingDag is a testDag whose AddMany blocks until `release` is closed.
+type blockingDag struct {
+	*testDag
+	release chan struct{}
+}
+
+func (d *blockingDag) AddMany(ctx context.Context, nodes []Node) error {
+	select {
+	case <-d.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return d.testDag.AddMany(ctx, nodes)
+}
+
+func TestBatchContexts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &blockingDag{testDag: newTestDag(), release: make(chan struct{})}
+	b := NewBatch(ctx, d, ParallelCommitsBatchOption(1), MaxNodesBatchOption(0))
+
+	// The first add is committed right away and blocks in the NodeAdder.
+	first := InitNode([]byte("first"))
+	if err := b.Add(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second one has to wait for a commit slot.
+	addCtx, addCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer addCancel()
+	second := InitNode([]byte("second"))
+	err := b.Add(addCtx, second)
+	if !errors.Is(err, ErrBatchPending{}) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the add to time out with the node batched, got %v", err)
+	}
+
+	commitCtx, commitCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer commitCancel()
+	if err := b.CommitContext(commitCtx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the commit to time out, got %v", err)
+	}
+
+	// The batch is still usable.
+	close(d.release)
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []Node{first, second} {
+		if _, err := d.Get(ctx, nd.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBatchClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newTestDag()
+	b := NewBatch(ctx, d)
+	nd := InitNode([]byte("closed"))
+	if err := b.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, nd.Cid()); err != nil {
+		t.Fatal("expected Close to commit the batch")
+	}
+	if err := b.Add(ctx, nd); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := b.Commit(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if err := b.Close(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+
+	errStorage := errors.New("storage failure")
+	b = NewBatch(ctx, failingAdder{errStorage})
+	if err := b.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	err := b.Close()
+	if !errors.Is(err, ErrNotCommited) || !errors.Is(err, errStorage) {
+		t.Fatalf("expected ErrNotCommited wrapping the cause, got %v", err)
+	}
+	if ops := b.Uncommitted(); len(ops) != 1 || ops[0].Node != nd {
+		t.Fatalf("expected the node to be reported as uncommitted, got %v", ops)
+	}
+}
+
+func TestBatchCloseConcurrentAdds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newTestDag()
+	b := NewBatch(ctx, d, ParallelCommitsBatchOption(2), MaxNodesBatchOption(4))
+
+	// Producers keep adding until the batch is closed.
+	const producers = 4
+	var wg sync.WaitGroup
+	accepted := make([][]Node, producers)
+	errs := make(chan error, producers)
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				nd := InitNode([]byte(fmt.Sprintf("%d-%d", p, i)))
+				if err := b.Add(ctx, nd); err != nil {
+					if err != ErrClosed {
+						errs <- err
+					}
+					return
+				}
+				accepted[p] = append(accepted[p], nd)
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	for _, nodes := range accepted {
+		for _, nd := range nodes {
+			if _, err := d.Get(ctx, nd.Cid()); err != nil {
+				t.Fatalf("expected every accepted node to be committed: %s", err)
+			}
+		}
+	}
+}
+
+func TestBatchAbort(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := &blockingDag{testDag: newTestDag(), release: make(chan struct{})}
+	b := NewBatch(ctx, d, ParallelCommitsBatchOption(1), MaxNodesBatchOption(1))
+
+	// Two nodes go to a commit blocked in the NodeAdder, the third one
+	// stays buffered.
+	nodes := []Node{InitNode([]byte("1")), InitNode([]byte("2")), InitNode([]byte("3"))}
+	if err := b.AddMany(ctx, nodes[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(ctx, nodes[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped := b.Abort()
+	if len(dropped) != 3 {
+		t.Fatalf("expected 3 dropped nodes, got %d", len(dropped))
+	}
+	for i := range nodes {
+		if dropped[i].Node != nodes[i] {
+			t.Fatalf("unexpected dropped node %d: %v", i, dropped[i])
+		}
+	}
+	if err := b.Add(ctx, nodes[0]); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+	if len(d.nodes) != 0 {
+		t.Fatal("no nodes should have been added")
+	}
+}
@@ -1,6 +1,7 @@
 package format
 
 import (
+	"errors"
 	"fmt"
 
 	blocks "github.com/ipfs/go-block-format"
@@ -41,6 +42,8 @@ func (r *Registry) Register(codec uint64, decoder DecodeBlockFunc) {
 	r.decoders[codec] = decoder
 }
 
+// Decode decodes the given block using the decoder registered for its codec.
+// Failures are reported as an ErrDecode.
 func (r *Registry) Decode(block blocks.Block) (Node, error) {
 	// Short-circuit by cast if we already have a Node.
 	if node, ok := block.(Node); ok {
@@ -52,14 +55,19 @@ func (r *Registry) Decode(block blocks.Block) (Node, error) {
 	decoder, ok := r.decoders[ty]
 
 	if ok {
-		return decoder(block)
+		return decode(block, decoder)
 	} else {
 		// TODO: get the *long* name for this format
-		return nil, fmt.Errorf("unrecognized object type: %d", ty)
+		return nil, ErrDecode{
+			Cid:   block.Cid(),
+			Codec: ty,
+			Cause: fmt.Errorf("unrecognized object type: %d", ty),
+		}
 	}
 }
 
-// Decode decodes the given block using passed DecodeBlockFunc.
+// Decode decodes the given block using passed DecodeBlockFunc. Failures are
+// reported as an ErrDecode.
 // Note: this is just a helper function, consider using the DecodeBlockFunc itself rather than this helper
 func Decode(block blocks.Block, decoder DecodeBlockFunc) (Node, error) {
 	// Short-circuit by cast if we already have a Node.
@@ -67,5 +75,22 @@ func Decode(block blocks.Block, decoder DecodeBlockFunc) (Node, error) {
 		return node, nil
 	}
 
-	return decoder(block)
+	return decode(block, decoder)
+}
+
+// decode calls the decoder wrapping its errors in an ErrDecode (unless
+// they already are one).
+func decode(block blocks.Block, decoder DecodeBlockFunc) (Node, error) {
+	node, err := decoder(block)
+	if err != nil {
+		if errors.Is(err, ErrDecode{}) {
+			return nil, err
+		}
+		return nil, ErrDecode{
+			Cid:   block.Cid(),
+			Codec: block.Cid().Type(),
+			Cause: err,
+		}
+	}
+	return node, nil
 }
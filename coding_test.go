@@ -74,8 +74,15 @@ func TestRegistryDecode(t *testing.T) {
 
 	reg := Registry{}
 	_, err = reg.Decode(block)
-	if err == nil || err.Error() != "unrecognized object type: 85" {
-		t.Fatalf("expected error, got %v", err)
+	var decodeErr ErrDecode
+	if !errors.As(err, &decodeErr) || decodeErr.Codec != cid.Raw || !decodeErr.Cid.Equals(id) {
+		t.Fatalf("expected ErrDecode, got %v", err)
+	}
+	if decodeErr.Cause.Error() != "unrecognized object type: 85" {
+		t.Fatalf("unexpected error cause: %v", decodeErr.Cause)
+	}
+	if !IsPermanent(err) {
+		t.Fatal("decode errors should be permanent")
 	}
 	reg.Register(cid.Raw, decoder)
 	node, err := reg.Decode(block)
@@ -0,0 +1,93 @@
+package format
+
+import (
+	"context"
+	"errors"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// MissingBlock is a block of a DAG reported by MissingBlocks.
+type MissingBlock struct {
+	Cid cid.Cid
+
+	// Parent is the CID of the node linking to the missing block, and Name
+	// the name of that link. Blocks linked from several nodes are reported
+	// once, for the first of them found. Parent is undefined when the
+	// missing block is the root.
+	Parent cid.Cid
+	Name   string
+}
+
+// errIncomplete stops the walk of IsComplete at the first missing block.
+var errIncomplete = errors.New("incomplete DAG")
+
+// IsComplete returns whether all the blocks of the DAG under root are
+// present in the NodeGetter, stopping at the first missing one. It is meant
+// to be used with a NodeGetter only doing local lookups: one fetching
+// missing blocks from the network would defeat its purpose.
+//
+// The DAG is walked breadth first, with one GetMany call per chunk of a
+// level (see walkLevels). Blocks are only checked against their CID, not
+// re-hashed. Errors other than ErrNotFound are returned.
+func IsComplete(ctx context.Context, ng NodeGetter, root cid.Cid) (bool, error) {
+	err := walkLevels(ctx, root, func(ctx context.Context, chunk []levelEntry) (map[string][]*Link, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		found := make(map[string][]*Link, len(chunk))
+		for opt := range GetManyKeyed(ctx, ng, levelKeys(chunk)) {
+			switch {
+			case IsNotFound(opt.Err):
+				return nil, errIncomplete
+			case opt.Err != nil:
+				return nil, opt.Err
+			}
+			if err := checkNode(opt.Cid, opt.Node); err != nil {
+				return nil, err
+			}
+			found[opt.Cid.KeyString()] = opt.Node.Links()
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Keys the NodeGetter didn't return are missing too.
+		if len(found) < len(chunk) {
+			return nil, errIncomplete
+		}
+		return found, nil
+	})
+	switch err {
+	case nil:
+		return true, nil
+	case errIncomplete:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// MissingBlocks is the reporting version of IsComplete: it walks the whole
+// DAG under root and returns all the blocks missing from the NodeGetter,
+// alongside the link they are missing from, in breadth first order. The
+// subtrees under missing blocks can't be walked, so blocks missing from them
+// are not reported.
+func MissingBlocks(ctx context.Context, ng NodeGetter, root cid.Cid) ([]MissingBlock, error) {
+	var missing []MissingBlock
+	err := walkLevels(ctx, root, func(ctx context.Context, chunk []levelEntry) (map[string][]*Link, error) {
+		found, err := getPresent(ctx, ng, levelKeys(chunk))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range chunk {
+			if _, ok := found[e.Cid.KeyString()]; !ok {
+				missing = append(missing, MissingBlock{Cid: e.Cid, Parent: e.Parent, Name: e.Name})
+			}
+		}
+		return nodeLinks(found), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
@@ -0,0 +1,111 @@
+package format
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestIsComplete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	leaf, _ := NewDataNode("leaf")
+	other, _ := NewDataNode("other")
+	deep, _ := NewDataNode("deep")
+	mid, _ := NewDataNode(map[string]interface{}{"deep": deep.Cid()})
+	root, _ := NewDataNode(map[string]interface{}{
+		"leaf":  leaf.Cid(),
+		"mid":   mid.Cid(),
+		"other": []interface{}{other.Cid(), leaf.Cid()},
+	})
+	for _, n := range []Node{leaf, other, deep, mid, root} {
+		ds.Add(ctx, n)
+	}
+
+	complete, err := IsComplete(ctx, ds, root.Cid())
+	if err != nil || !complete {
+		t.Fatalf("expected a complete DAG: %v", err)
+	}
+	missing, err := MissingBlocks(ctx, ds, root.Cid())
+	if err != nil || len(missing) != 0 {
+		t.Fatalf("expected no missing blocks, got %v: %v", missing, err)
+	}
+
+	ds.Remove(ctx, leaf.Cid())
+	ds.Remove(ctx, deep.Cid())
+	complete, err = IsComplete(ctx, ds, root.Cid())
+	if err != nil || complete {
+		t.Fatalf("expected an incomplete DAG: %v", err)
+	}
+	missing, err = MissingBlocks(ctx, ds, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MissingBlock{
+		{Cid: leaf.Cid(), Parent: root.Cid(), Name: "leaf"},
+		{Cid: deep.Cid(), Parent: mid.Cid(), Name: "deep"},
+	}
+	if len(missing) != len(want) {
+		t.Fatalf("expected %v, got %v", want, missing)
+	}
+	for i := range want {
+		if missing[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want[i], missing[i])
+		}
+	}
+
+	// A missing root.
+	ds.Remove(ctx, root.Cid())
+	missing, err = MissingBlocks(ctx, ds, root.Cid())
+	if err != nil || len(missing) != 1 || missing[0].Parent != cid.Undef {
+		t.Fatalf("expected the root to be missing, got %v: %v", missing, err)
+	}
+	if complete, err := IsComplete(ctx, ds, root.Cid()); err != nil || complete {
+		t.Fatalf("expected an incomplete DAG: %v", err)
+	}
+
+	// A getter returning no node nor error.
+	ds.Add(ctx, root)
+	nils := cid.NewSet()
+	nils.Add(root.Cid())
+	if _, err := IsComplete(ctx, nilGetter{ds, nils}, root.Cid()); err == nil {
+		t.Fatal("expected a missing node to fail")
+	}
+	if _, err := MissingBlocks(ctx, nilGetter{ds, nils}, root.Cid()); err == nil {
+		t.Fatal("expected a missing node to fail")
+	}
+}
+
+func TestIsCompleteChunks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+	nodes := addWideDAG(t, ds, 2*levelChunkSize+1)
+	root := nodes[len(nodes)-1]
+
+	g := &recordingGetter{NodeGetter: ds}
+	if complete, err := IsComplete(ctx, g, root.Cid()); err != nil || !complete {
+		t.Fatalf("expected a complete DAG: %v", err)
+	}
+	if g.maxBatch > levelChunkSize {
+		t.Fatalf("expected at most %d blocks fetched at once, got %d", levelChunkSize, g.maxBatch)
+	}
+
+	// A block missing from the last chunk.
+	last := nodes[len(nodes)-2]
+	ds.Remove(ctx, last.Cid())
+	missing, err := MissingBlocks(ctx, g, root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || !missing[0].Cid.Equals(last.Cid()) || missing[0].Name != fmt.Sprint(2*levelChunkSize) {
+		t.Fatalf("expected the last leaf to be missing, got %v", missing)
+	}
+	if g.maxBatch > levelChunkSize {
+		t.Fatalf("expected at most %d blocks fetched at once, got %d", levelChunkSize, g.maxBatch)
+	}
+}
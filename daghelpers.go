@@ -1,7 +1,12 @@
 package format
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
 
 	cid "github.com/ipfs/go-cid"
 )
@@ -37,6 +42,13 @@ func GetDAG(ctx context.Context, ds NodeGetter, root Node) []*NodePromise {
 
 // GetNodes returns an array of 'FutureNode' promises, with each corresponding
 // to the key with the same index as the passed in keys
+//
+// Failures are attributed to the key they refer to whenever possible (see
+// GetManyKeyed): only the promises for that key fail and the rest keep
+// resolving. Keys the NodeGetter never returned fail with an ErrNotFound
+// carrying the key, and nodes reporting a CID with another multihash than
+// their key fail with an ErrHashMismatch. Use MissingKeys to obtain a summary of the keys that
+// could not be found.
 func GetNodes(ctx context.Context, ds NodeGetter, keys []cid.Cid) []*NodePromise {
 	// Early out if no work to do
 	if len(keys) == 0 {
@@ -53,33 +65,45 @@ func GetNodes(ctx context.Context, ds NodeGetter, keys []cid.Cid) []*NodePromise
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		nodechan := ds.GetMany(ctx, dedupedKeys)
+		nodechan := GetManyKeyed(ctx, ds, dedupedKeys)
+
+		// settle settles all the (unsettled) promises for the key c,
+		// returning how many there were.
+		settle := func(c cid.Cid, nd Node, err error) int {
+			n := 0
+			for i, k := range keys {
+				if c.Equals(k) && promises[i].settle(nd, err) {
+					n++
+				}
+			}
+			return n
+		}
 
 		for count := 0; count < len(keys); {
 			select {
 			case opt, ok := <-nodechan:
 				if !ok {
-					for _, p := range promises {
-						p.Fail(ErrNotFound{})
+					for i, p := range promises {
+						p.Fail(ErrNotFound{Cid: keys[i]})
 					}
 					return
 				}
 
-				if opt.Err != nil {
+				if !opt.Cid.Defined() {
+					// The error can't be attributed to any key.
 					for _, p := range promises {
 						p.Fail(opt.Err)
 					}
 					return
 				}
 
-				nd := opt.Node
-				c := nd.Cid()
-				for i, lnk_c := range keys {
-					if c.Equals(lnk_c) {
-						count++
-						promises[i].Send(nd)
+				nd, err := opt.Node, opt.Err
+				if err == nil {
+					if err = checkNode(opt.Cid, nd); err != nil {
+						nd = nil
 					}
 				}
+				count += settle(opt.Cid, nd, err)
 			case <-ctx.Done():
 				return
 			}
@@ -88,19 +112,187 @@ func GetNodes(ctx context.Context, ds NodeGetter, keys []cid.Cid) []*NodePromise
 	return promises
 }
 
-func Copy(ctx context.Context, from, to DAGService, root cid.Cid) error {
-	node, err := from.Get(ctx, root)
+// GetManyKeyed calls GetMany on the given NodeGetter and makes sure every
+// NodeOption received reports the requested CID it refers to. Options that
+// don't have their Cid set are completed with the CID of their Node or, for
+// failures, with the one of the ErrNotFound they wrap. Errors that can't be
+// attributed to any CID are passed along with an undefined Cid.
+func GetManyKeyed(ctx context.Context, ng NodeGetter, keys []cid.Cid) <-chan *NodeOption {
+	in := ng.GetMany(ctx, keys)
+	out := make(chan *NodeOption)
+	go func() {
+		defer close(out)
+		for opt := range in {
+			if !opt.Cid.Defined() {
+				keyed := *opt
+				keyed.Cid = optionCid(opt)
+				opt = &keyed
+			}
+			select {
+			case out <- opt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// optionCid extracts the CID a NodeOption refers to from its contents.
+func optionCid(opt *NodeOption) cid.Cid {
+	if opt.Err == nil {
+		if opt.Node == nil {
+			return cid.Undef
+		}
+		return opt.Node.Cid()
+	}
+	var notFound ErrNotFound
+	if errors.As(opt.Err, &notFound) {
+		return notFound.Cid
+	}
+	return cid.Undef
+}
+
+// checkNode checks that nd is the node with the CID c, failing with an
+// ErrHashMismatch if it reports a CID with another multihash. CIDs that only
+// differ in their version or codec address the same block. The data is not
+// re-hashed, nodes are trusted to report their own CID.
+//
+// A nil node, returned by a NodeGetter without an error, fails too.
+func checkNode(c cid.Cid, nd Node) error {
+	if nd == nil {
+		return fmt.Errorf("ipld: no node nor error returned for %s", c)
+	}
+	if got := nd.Cid(); !bytes.Equal(got.Hash(), c.Hash()) {
+		return ErrHashMismatch{Cid: c, Got: got}
+	}
+	return nil
+}
+
+// MissingKeys blocks until all the promises returned by GetNodes for the
+// given keys are settled and returns the keys that could not be found
+// in order and without duplicates. Any other failure, like a canceled
+// context, is returned as an error.
+func MissingKeys(ctx context.Context, keys []cid.Cid, promises []*NodePromise) ([]cid.Cid, error) {
+	var missing []cid.Cid
+	seen := cid.NewSet()
+	for i, p := range promises {
+		_, err := p.Get(ctx)
+		switch {
+		case err == nil:
+		case IsNotFound(err):
+			if seen.Visit(keys[i]) {
+				missing = append(missing, keys[i])
+			}
+		default:
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// Copy copies the DAG under root from one DAGService into another. Nodes are
+// added to `to` after all of their children.
+//
+// The traversal fails with ErrCycleDetected if a node links back to one of
+// its ancestors; see TraversalOption for ways to further bound it.
+func Copy(ctx context.Context, from, to DAGService, root cid.Cid, opts ...TraversalOption) error {
+	c := &copier{
+		from:   from,
+		to:     to,
+		limits: newTraversalLimits(opts),
+	}
+	return c.copy(ctx, root)
+}
+
+type copier struct {
+	from, to DAGService
+	limits   *traversalLimits
+
+	// CIDs of the nodes from the root to the one being copied.
+	ancestors []cid.Cid
+}
+
+func (c *copier) copy(ctx context.Context, root cid.Cid) error {
+	if err := c.limits.enter(len(c.ancestors), root, slices.Values(c.ancestors)); err != nil {
+		return err
+	}
+	node, err := c.from.Get(ctx, root)
 	if err != nil {
 		return err
 	}
-	links := node.Links()
-	for _, link := range links {
-		err := Copy(ctx, from, to, link.Cid)
+
+	c.ancestors = append(c.ancestors, root)
+	for _, link := range node.Links() {
+		err := c.copy(ctx, link.Cid)
 		if err != nil {
 			return err
 		}
 	}
-	return to.Add(ctx, node)
+	c.ancestors = c.ancestors[:len(c.ancestors)-1]
+
+	return c.to.Add(ctx, node)
+}
+
+// TraversalOption provides a way of bounding the DAG traversals performed
+// by helpers like Copy and the Walker. Services processing untrusted DAGs
+// should set these to get a hard safety bound.
+type TraversalOption func(o *traversalOptions)
+
+type traversalOptions struct {
+	maxDepth int
+	maxNodes int
+}
+
+// MaxDepthTraversalOption sets the maximum depth (the root being at depth
+// zero) a traversal may descend to before failing with ErrMaxDepthExceeded.
+// Values lower or equal than zero (the default) disable the limit.
+func MaxDepthTraversalOption(depth int) TraversalOption {
+	return func(o *traversalOptions) {
+		o.maxDepth = depth
+	}
+}
+
+// MaxNodesTraversalOption sets the maximum number of nodes a traversal may
+// visit before failing with ErrMaxNodesExceeded. Nodes reachable through
+// more than one path count once per visit. Values lower or equal than zero
+// (the default) disable the limit.
+func MaxNodesTraversalOption(num int) TraversalOption {
+	return func(o *traversalOptions) {
+		o.maxNodes = num
+	}
+}
+
+// traversalLimits enforces the traversalOptions over a single traversal.
+type traversalLimits struct {
+	opts    traversalOptions
+	visited int
+}
+
+func newTraversalLimits(opts []TraversalOption) *traversalLimits {
+	var topts traversalOptions
+	for _, o := range opts {
+		o(&topts)
+	}
+	return &traversalLimits{opts: topts}
+}
+
+// enter checks whether the node `c` at `depth`, reached through `ancestors`,
+// can be visited and accounts for it.
+func (l *traversalLimits) enter(depth int, c cid.Cid, ancestors iter.Seq[cid.Cid]) error {
+	if l.opts.maxDepth > 0 && depth > l.opts.maxDepth {
+		return ErrMaxDepthExceeded{MaxDepth: l.opts.maxDepth}
+	}
+	for a := range ancestors {
+		if a.Equals(c) {
+			return ErrCycleDetected{Cid: c}
+		}
+	}
+	if l.opts.maxNodes > 0 && l.visited >= l.opts.maxNodes {
+		return ErrMaxNodesExceeded{MaxNodes: l.opts.maxNodes}
+	}
+	l.visited++
+	return nil
 }
 
 // Remove duplicates from a list of keys
@@ -2,6 +2,7 @@ package format
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/ipfs/go-cid"
@@ -140,3 +141,227 @@ func TestCopy(t *testing.T) {
 		t.Error("fail to copy dag")
 	}
 }
+
+func TestCopyLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	from := newTestDag()
+
+	// A chain of 4 nodes.
+	nodes := []*TestNode{
+		InitNode([]byte("chain0")),
+		InitNode([]byte("chain1")),
+		InitNode([]byte("chain2")),
+		InitNode([]byte("chain3")),
+	}
+	for i := 0; i < len(nodes)-1; i++ {
+		nodes[i].AddNodeLink("next", nodes[i+1])
+	}
+	for _, n := range nodes {
+		if err := from.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := nodes[0].Cid()
+
+	err := Copy(ctx, from, newTestDag(), root, MaxDepthTraversalOption(2))
+	if !errors.Is(err, ErrMaxDepthExceeded{}) {
+		t.Fatalf("expected ErrMaxDepthExceeded, got %v", err)
+	}
+	err = Copy(ctx, from, newTestDag(), root, MaxNodesTraversalOption(3))
+	if !errors.Is(err, ErrMaxNodesExceeded{}) {
+		t.Fatalf("expected ErrMaxNodesExceeded, got %v", err)
+	}
+	err = Copy(ctx, from, newTestDag(), root, MaxDepthTraversalOption(3), MaxNodesTraversalOption(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Close the chain into a cycle. The CID of a TestNode only depends
+	// on its data so this is possible.
+	nodes[3].AddNodeLink("next", nodes[1])
+	err = Copy(ctx, from, newTestDag(), root)
+	var cycleErr ErrCycleDetected
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("expected ErrCycleDetected, got %v", err)
+	}
+	if !cycleErr.Cid.Equals(nodes[1].Cid()) {
+		t.Fatalf("cycle detected at %s, expected %s", cycleErr.Cid, nodes[1].Cid())
+	}
+}
+
+func TestGetNodesPartialFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	present := InitNode([]byte("present"))
+	if err := ds.Add(ctx, present); err != nil {
+		t.Fatal(err)
+	}
+	missing := InitNode([]byte("missing"))
+
+	keys := []cid.Cid{missing.Cid(), present.Cid(), missing.Cid(), present.Cid()}
+	promises := GetNodes(ctx, ds, keys)
+
+	for i, p := range promises {
+		nd, err := p.Get(ctx)
+		if keys[i].Equals(present.Cid()) {
+			if err != nil || !nd.Cid().Equals(present.Cid()) {
+				t.Fatalf("promise %d: expected the node, got %v", i, err)
+			}
+			continue
+		}
+		var notFound ErrNotFound
+		if !errors.As(err, &notFound) || !notFound.Cid.Equals(missing.Cid()) {
+			t.Fatalf("promise %d: expected ErrNotFound for %s, got %v", i, missing.Cid(), err)
+		}
+	}
+
+	missingKeys, err := MissingKeys(ctx, keys, promises)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missingKeys) != 1 || !missingKeys[0].Equals(missing.Cid()) {
+		t.Fatalf("unexpected missing keys: %v", missingKeys)
+	}
+}
+
+func TestGetManyKeyed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	present := InitNode([]byte("present"))
+	if err := ds.Add(ctx, present); err != nil {
+		t.Fatal(err)
+	}
+	missing := InitNode([]byte("missing"))
+
+	got := make(map[cid.Cid]error)
+	for opt := range GetManyKeyed(ctx, ds, []cid.Cid{present.Cid(), missing.Cid()}) {
+		if !opt.Cid.Defined() {
+			t.Fatalf("option without a CID: %v", opt)
+		}
+		got[opt.Cid] = opt.Err
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 options, got %d", len(got))
+	}
+	if err := got[present.Cid()]; err != nil {
+		t.Fatal(err)
+	}
+	if err := got[missing.Cid()]; !IsNotFound(err) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// lyingGetter returns the same node for any requested CID.
+type lyingGetter struct {
+	nd Node
+}
+
+func (g lyingGetter) Get(ctx context.Context, c cid.Cid) (Node, error) {
+	return g.nd, nil
+}
+
+func (g lyingGetter) GetMany(ctx context.Context, cs []cid.Cid) <-chan *NodeOption {
+	out := make(chan *NodeOption, len(cs))
+	for _, c := range cs {
+		out <- &NodeOption{Node: g.nd, Cid: c}
+	}
+	close(out)
+	return out
+}
+
+func TestGetNodesHashMismatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requested := InitNode([]byte("requested"))
+	returned := InitNode([]byte("returned"))
+	promises := GetNodes(ctx, lyingGetter{returned}, []cid.Cid{requested.Cid()})
+
+	_, err := promises[0].Get(ctx)
+	var mismatch ErrHashMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+	if !mismatch.Cid.Equals(requested.Cid()) || !mismatch.Got.Equals(returned.Cid()) {
+		t.Fatalf("unexpected mismatch: %v", mismatch)
+	}
+}
+
+// cidNode is a TestNode reporting the given CID.
+type cidNode struct {
+	*TestNode
+	cid cid.Cid
+}
+
+func (n *cidNode) Cid() cid.Cid {
+	return n.cid
+}
+
+func TestGetNodesMultihash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The same block under a CIDv1 is accepted.
+	requested := InitNode([]byte("requested"))
+	v1 := cid.NewCidV1(cid.DagProtobuf, requested.Cid().Hash())
+	promises := GetNodes(ctx, lyingGetter{requested}, []cid.Cid{v1})
+	if nd, err := promises[0].Get(ctx); err != nil || nd != Node(requested) {
+		t.Fatalf("expected the node to be accepted, got %v", err)
+	}
+
+	// So are nodes whose hash function isn't known.
+	digest, err := mh.Encode([]byte("digest"), 0x1012)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := cid.NewCidV1(cid.Raw, digest)
+	nd := &cidNode{InitNode([]byte("unknown")), unknown}
+	promises = GetNodes(ctx, lyingGetter{nd}, []cid.Cid{unknown})
+	if got, err := promises[0].Get(ctx); err != nil || got != Node(nd) {
+		t.Fatalf("expected the node to be accepted, got %v", err)
+	}
+}
+
+// nilGetter returns nil nodes without errors for the keys in nils.
+type nilGetter struct {
+	*testDag
+	nils *cid.Set
+}
+
+func (g nilGetter) GetMany(ctx context.Context, cs []cid.Cid) <-chan *NodeOption {
+	out := make(chan *NodeOption, len(cs))
+	for _, c := range cs {
+		if g.nils.Has(c) {
+			out <- &NodeOption{Cid: c}
+			continue
+		}
+		nd, err := g.testDag.Get(ctx, c)
+		out <- &NodeOption{Node: nd, Err: err, Cid: c}
+	}
+	close(out)
+	return out
+}
+
+func TestGetNodesNilNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ds := newTestDag()
+	present, absent := InitNode([]byte("present")), InitNode([]byte("absent"))
+	ds.Add(ctx, present)
+	nils := cid.NewSet()
+	nils.Add(absent.Cid())
+
+	promises := GetNodes(ctx, nilGetter{ds, nils}, []cid.Cid{absent.Cid(), present.Cid()})
+	if nd, err := promises[0].Get(ctx); err == nil || nd != nil {
+		t.Fatalf("expected a nil node to fail, got %v", nd)
+	}
+	if nd, err := promises[1].Get(ctx); err != nil || nd != Node(present) {
+		t.Fatalf("expected the other node to resolve, got %v", err)
+	}
+}
@@ -0,0 +1,599 @@
+package format
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// DataNodeCodec is the multicodec of DataNode blocks. It belongs to the
+// private use range of the multicodec table: DataNodes are meant for tests
+// and tooling, not for data exchanged with other implementations.
+const DataNodeCodec uint64 = 0x300000
+
+// DataNode is a general purpose Node holding structured data: nested maps
+// and lists of scalars and links, like the data model of dag-cbor or
+// dag-json. It is meant to build realistic DAGs without depending on any
+// codec implementation.
+//
+// The values a DataNode can hold are:
+//
+//   - nil, bool, int64, string (valid UTF-8) and []byte,
+//   - map[string]interface{} (with keys not containing "/") and
+//     []interface{} of values,
+//   - cid.Cid (or *Link, of which only the CID is kept) for links.
+//
+// Other integer types are converted to int64, failing for unsigned values
+// above math.MaxInt64. Map keys can't contain slashes as they separate the
+// segments of the paths and link names of the node.
+//
+// DataNodes are serialized as deterministic JSON, following the dag-json
+// conventions: map keys are sorted, links are encoded as {"/": "<cid>"} and
+// bytes as {"/": {"bytes": "<unpadded base64>"}}.
+type DataNode struct {
+	blocks.Block
+
+	value interface{}
+	links []*Link
+}
+
+var _ LinkEditable = (*DataNode)(nil)
+
+// NewDataNode creates a DataNode holding the given value, using a CIDv1
+// with the DataNodeCodec codec and a sha2-256 multihash.
+func NewDataNode(value interface{}) (*DataNode, error) {
+	return NewDataNodeWPrefix(value, cid.Prefix{
+		Version:  1,
+		Codec:    DataNodeCodec,
+		MhType:   mh.SHA2_256,
+		MhLength: -1,
+	})
+}
+
+// NewDataNodeWPrefix creates a DataNode holding the given value, with a CID
+// built by the given builder. The codec of the CID is always DataNodeCodec.
+func NewDataNodeWPrefix(value interface{}, builder cid.Builder) (*DataNode, error) {
+	value, err := normalizeData(value, nil)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encodeData(&buf, value)
+
+	c, err := builder.WithCodec(DataNodeCodec).Sum(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	blk, err := blocks.NewBlockWithCid(buf.Bytes(), c)
+	if err != nil {
+		return nil, err
+	}
+	return newDataNode(blk, value), nil
+}
+
+func newDataNode(blk blocks.Block, value interface{}) *DataNode {
+	n := &DataNode{Block: blk, value: value}
+	walkData(value, nil, func(path []string, v interface{}) {
+		if c, ok := v.(cid.Cid); ok {
+			n.links = append(n.links, &Link{Name: strings.Join(path, "/"), Cid: c})
+		}
+	})
+	return n
+}
+
+// DecodeDataNode is a DecodeBlockFunc for DataNode blocks. Only blocks in
+// the exact form NewDataNode produces are accepted, so every value has a
+// single block (and CID).
+func DecodeDataNode(block blocks.Block) (Node, error) {
+	if block.Cid().Type() != DataNodeCodec {
+		return nil, fmt.Errorf("data nodes cannot be decoded from codec 0x%x", block.Cid().Type())
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(block.RawData()))
+	dec.UseNumber()
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	value, err := fromJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encodeData(&buf, value)
+	if !bytes.Equal(buf.Bytes(), block.RawData()) {
+		return nil, errors.New("data node is not in canonical form")
+	}
+	return newDataNode(block, value), nil
+}
+
+// RegisterDataNode registers DecodeDataNode in the given Registry for the
+// DataNodeCodec codec.
+func RegisterDataNode(r *Registry) {
+	r.Register(DataNodeCodec, DecodeDataNode)
+}
+
+// Value returns a copy of the value held by the node, in the normalized
+// form described in DataNode.
+func (n *DataNode) Value() interface{} {
+	return copyData(n.value)
+}
+
+// Resolve resolves a path through the value of the node: map keys and list
+// indexes. It stops at the first link, returning it as a *Link alongside
+// the rest of the path.
+func (n *DataNode) Resolve(path []string) (interface{}, []string, error) {
+	v := n.value
+	for i, seg := range path {
+		if c, ok := v.(cid.Cid); ok {
+			return &Link{Cid: c}, path[i:], nil
+		}
+		next, ok := dataChild(v, seg)
+		if !ok {
+			return nil, nil, ErrPathNotFound{Cid: n.Cid(), Remaining: path[i:]}
+		}
+		v = next
+	}
+	if c, ok := v.(cid.Cid); ok {
+		return &Link{Cid: c}, nil, nil
+	}
+	return copyData(v), nil, nil
+}
+
+// ResolveLink resolves a path like Resolve does, failing if it doesn't
+// lead to a link.
+func (n *DataNode) ResolveLink(path []string) (*Link, []string, error) {
+	v, rest, err := n.Resolve(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	lnk, ok := v.(*Link)
+	if !ok {
+		return nil, nil, fmt.Errorf("found non-link at %q", strings.Join(path, "/"))
+	}
+	return lnk, rest, nil
+}
+
+// Tree lists the paths within the value under path (relative to it), up to
+// the given depth. Links are listed but not followed.
+func (n *DataNode) Tree(path string, depth int) []string {
+	v := n.value
+	if path != "" {
+		for _, seg := range strings.Split(path, "/") {
+			next, ok := dataChild(v, seg)
+			if !ok {
+				return nil
+			}
+			v = next
+		}
+	}
+
+	var paths []string
+	walkData(v, nil, func(p []string, _ interface{}) {
+		if len(p) > 0 && (depth < 0 || len(p) <= depth) {
+			paths = append(paths, strings.Join(p, "/"))
+		}
+	})
+	return paths
+}
+
+// Copy returns a deep copy of the node.
+func (n *DataNode) Copy() Node {
+	blk, err := blocks.NewBlockWithCid(slices.Clone(n.RawData()), n.Cid())
+	if err != nil {
+		// The CID was already validated against the same data.
+		panic(err)
+	}
+	return newDataNode(blk, copyData(n.value))
+}
+
+// Links returns the links of the node, named after their path within it,
+// in the order Tree lists them.
+func (n *DataNode) Links() []*Link {
+	links := make([]*Link, len(n.links))
+	for i, l := range n.links {
+		lnk := *l
+		links[i] = &lnk
+	}
+	return links
+}
+
+// Size returns the size of the serialized node.
+func (n *DataNode) Size() (uint64, error) {
+	return uint64(len(n.RawData())), nil
+}
+
+// Stat returns stats about the node. Links are part of the data, so all of
+// the block counts as data.
+func (n *DataNode) Stat() (*NodeStat, error) {
+	size := len(n.RawData())
+	return &NodeStat{
+		Hash:           n.Cid().String(),
+		NumLinks:       len(n.links),
+		BlockSize:      size,
+		DataSize:       size,
+		CumulativeSize: size,
+	}, nil
+}
+
+// AddLink returns a copy of the node with a link at the path given as name
+// (see Links), creating the maps leading to it as needed. A link can be
+// appended to a list by using its length as index. It fails if the path
+// already exists.
+func (n *DataNode) AddLink(name string, l *Link) (Node, error) {
+	return n.editLink(name, true, func(_ interface{}, exists bool) (interface{}, bool, error) {
+		if exists {
+			return nil, false, fmt.Errorf("%q already exists", name)
+		}
+		return l.Cid, false, nil
+	})
+}
+
+// RemoveLink returns a copy of the node without the link at the path given
+// as name. Links in lists are removed from them, shifting the following
+// elements.
+func (n *DataNode) RemoveLink(name string) (Node, error) {
+	return n.editLink(name, false, func(_ interface{}, _ bool) (interface{}, bool, error) {
+		return nil, true, nil
+	})
+}
+
+// ReplaceLink returns a copy of the node with the link at the path given as
+// name pointing to the target of l.
+func (n *DataNode) ReplaceLink(name string, l *Link) (Node, error) {
+	return n.editLink(name, false, func(_ interface{}, _ bool) (interface{}, bool, error) {
+		return l.Cid, false, nil
+	})
+}
+
+// editLink edits the value at the path given as name with fn. Unless
+// adding, the path must lead to a link.
+func (n *DataNode) editLink(name string, add bool, fn dataEditFunc) (Node, error) {
+	path := strings.Split(name, "/")
+	if !add {
+		edit := fn
+		fn = func(old interface{}, exists bool) (interface{}, bool, error) {
+			if _, ok := old.(cid.Cid); !ok {
+				return nil, false, errDataPathNotFound
+			}
+			return edit(old, exists)
+		}
+	}
+	value, err := editData(n.value, path, add, fn)
+	if err == errDataPathNotFound {
+		return nil, ErrPathNotFound{Cid: n.Cid(), Remaining: path}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewDataNodeWPrefix(value, n.Cid().Prefix())
+}
+
+// dataEditFunc returns the new value for an edited path given the current
+// one, or whether to remove it.
+type dataEditFunc func(old interface{}, exists bool) (value interface{}, remove bool, err error)
+
+var errDataPathNotFound = errors.New("data path not found")
+
+// editData returns a copy of the normalized value v with the value at path
+// edited by fn, sharing the parts that aren't modified. Missing maps are
+// created along the path if create is set.
+func editData(v interface{}, path []string, create bool, fn dataEditFunc) (interface{}, error) {
+	seg, last := path[0], len(path) == 1
+	switch v := v.(type) {
+	case map[string]interface{}:
+		old, exists := v[seg]
+		var nv interface{}
+		remove := false
+		var err error
+		switch {
+		case last:
+			nv, remove, err = fn(old, exists)
+		case !exists && create:
+			nv, err = editData(map[string]interface{}{}, path[1:], create, fn)
+		case !exists:
+			err = errDataPathNotFound
+		default:
+			nv, err = editData(old, path[1:], create, fn)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m := maps.Clone(v)
+		if remove {
+			delete(m, seg)
+		} else {
+			m[seg] = nv
+		}
+		return m, nil
+	case []interface{}:
+		i, err := strconv.Atoi(seg)
+		if err != nil || i < 0 || i > len(v) || strconv.Itoa(i) != seg {
+			return nil, errDataPathNotFound
+		}
+		exists := i < len(v)
+		if !exists && !last {
+			return nil, errDataPathNotFound
+		}
+		var old, nv interface{}
+		if exists {
+			old = v[i]
+		}
+		remove := false
+		if last {
+			nv, remove, err = fn(old, exists)
+		} else {
+			nv, err = editData(old, path[1:], create, fn)
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case remove:
+			return slices.Delete(slices.Clone(v), i, i+1), nil
+		case exists:
+			l := slices.Clone(v)
+			l[i] = nv
+			return l, nil
+		default:
+			return append(slices.Clone(v), nv), nil
+		}
+	default:
+		return nil, errDataPathNotFound
+	}
+}
+
+// normalizeData validates a value and converts it to the form described in
+// DataNode, copying it so the caller can't modify the node.
+func normalizeData(v interface{}, path []string) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint:
+		return normalizeUint(uint64(v), path)
+	case uint64:
+		return normalizeUint(v, path)
+	case uintptr:
+		return normalizeUint(uint64(v), path)
+	case string:
+		if !utf8.ValidString(v) {
+			return nil, fmt.Errorf("invalid UTF-8 string at %q", strings.Join(path, "/"))
+		}
+		return v, nil
+	case []byte:
+		return slices.Clone(v), nil
+	case cid.Cid:
+		if !v.Defined() {
+			return nil, fmt.Errorf("undefined CID at %q", strings.Join(path, "/"))
+		}
+		return v, nil
+	case *Link:
+		return normalizeData(v.Cid, path)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if !utf8.ValidString(k) {
+				return nil, fmt.Errorf("invalid UTF-8 key at %q", strings.Join(path, "/"))
+			}
+			if strings.Contains(k, "/") {
+				return nil, fmt.Errorf("key %q with a slash at %q", k, strings.Join(path, "/"))
+			}
+			e, err := normalizeData(e, append(path, k))
+			if err != nil {
+				return nil, err
+			}
+			m[k] = e
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			e, err := normalizeData(e, append(path, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			l[i] = e
+		}
+		return l, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T at %q", v, strings.Join(path, "/"))
+	}
+}
+
+// normalizeUint converts an unsigned integer to int64, failing if it
+// doesn't fit.
+func normalizeUint(v uint64, path []string) (interface{}, error) {
+	if v > math.MaxInt64 {
+		return nil, fmt.Errorf("integer %d overflows int64 at %q", v, strings.Join(path, "/"))
+	}
+	return int64(v), nil
+}
+
+// copyData returns a deep copy of a normalized value.
+func copyData(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return slices.Clone(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = copyData(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = copyData(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// dataChild returns the child of a normalized value for a path segment.
+func dataChild(v interface{}, seg string) (interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		e, ok := v[seg]
+		return e, ok
+	case []interface{}:
+		i, err := strconv.Atoi(seg)
+		if err != nil || i < 0 || i >= len(v) || strconv.Itoa(i) != seg {
+			return nil, false
+		}
+		return v[i], true
+	default:
+		return nil, false
+	}
+}
+
+// walkData calls fn with every value within a normalized value and its
+// path, depth first and with map keys in order, without following links.
+func walkData(v interface{}, path []string, fn func([]string, interface{})) {
+	fn(path, v)
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			walkData(v[k], append(path, k), fn)
+		}
+	case []interface{}:
+		for i, e := range v {
+			walkData(e, append(path, strconv.Itoa(i)), fn)
+		}
+	}
+}
+
+// encodeData writes the canonical JSON encoding of a normalized value.
+func encodeData(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case string:
+		encodeDataString(buf, v)
+	case []byte:
+		buf.WriteString(`{"/":{"bytes":`)
+		encodeDataString(buf, base64.RawStdEncoding.EncodeToString(v))
+		buf.WriteString("}}")
+	case cid.Cid:
+		buf.WriteString(`{"/":`)
+		encodeDataString(buf, v.String())
+		buf.WriteByte('}')
+	case map[string]interface{}:
+		buf.WriteByte('{')
+		for i, k := range slices.Sorted(maps.Keys(v)) {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodeDataString(buf, k)
+			buf.WriteByte(':')
+			encodeData(buf, v[k])
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			encodeData(buf, e)
+		}
+		buf.WriteByte(']')
+	default:
+		panic(fmt.Sprintf("unexpected type %T in data node", v))
+	}
+}
+
+func encodeDataString(buf *bytes.Buffer, s string) {
+	// Strings are valid UTF-8, which json.Marshal encodes deterministically.
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+// fromJSON converts a value decoded by encoding/json back to a normalized
+// value.
+func fromJSON(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, bool, string:
+		return v, nil
+	case json.Number:
+		i, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported number %s", v)
+		}
+		return i, nil
+	case map[string]interface{}:
+		if special, ok := v["/"]; ok && len(v) == 1 {
+			return fromJSONSpecial(special)
+		}
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if strings.Contains(k, "/") {
+				return nil, fmt.Errorf("key %q with a slash", k)
+			}
+			e, err := fromJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = e
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			e, err := fromJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = e
+		}
+		return l, nil
+	default:
+		return nil, fmt.Errorf("unexpected JSON value %T", v)
+	}
+}
+
+// fromJSONSpecial decodes the value of a map with a single "/" key: a link
+// or bytes.
+func fromJSONSpecial(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return cid.Decode(v)
+	case map[string]interface{}:
+		if s, ok := v["bytes"].(string); ok && len(v) == 1 {
+			return base64.RawStdEncoding.DecodeString(s)
+		}
+	}
+	return nil, errors.New(`invalid value under "/" key`)
+}
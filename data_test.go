@@ -0,0 +1,266 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestDataNode(t *testing.T) {
+	leaf := NewRawNode([]byte("leaf"))
+	nd, err := NewDataNode(map[string]interface{}{
+		"name":  "root",
+		"count": 3,
+		"bytes": []byte{0, 1, 2},
+		"list":  []interface{}{true, nil, leaf.Cid()},
+		"child": map[string]interface{}{"leaf": &Link{Cid: leaf.Cid()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd.Cid().Type() != DataNodeCodec {
+		t.Fatalf("unexpected CID %s", nd.Cid())
+	}
+
+	want := `{"bytes":{"/":{"bytes":"AAEC"}},"child":{"leaf":{"/":"` + leaf.Cid().String() +
+		`"}},"count":3,"list":[true,null,{"/":"` + leaf.Cid().String() + `"}],"name":"root"}`
+	if string(nd.RawData()) != want {
+		t.Fatalf("unexpected serialization:\n%s\nexpected:\n%s", nd.RawData(), want)
+	}
+
+	for _, tc := range []struct {
+		path []string
+		want interface{}
+		rest []string
+	}{
+		{[]string{"name"}, "root", nil},
+		{[]string{"count"}, int64(3), nil},
+		{[]string{"bytes"}, []byte{0, 1, 2}, nil},
+		{[]string{"list", "0"}, true, nil},
+		{[]string{"list", "1"}, nil, nil},
+		{[]string{"list", "2"}, &Link{Cid: leaf.Cid()}, nil},
+		{[]string{"child", "leaf", "x"}, &Link{Cid: leaf.Cid()}, []string{"x"}},
+		{[]string{"child"}, map[string]interface{}{"leaf": leaf.Cid()}, nil},
+	} {
+		v, rest, err := nd.Resolve(tc.path)
+		if err != nil {
+			t.Fatalf("%v: %s", tc.path, err)
+		}
+		if !reflect.DeepEqual(v, tc.want) || !slices.Equal(rest, tc.rest) {
+			t.Fatalf("%v: unexpected resolution %#v, %v", tc.path, v, rest)
+		}
+	}
+	for _, p := range [][]string{{"nope"}, {"name", "x"}, {"list", "3"}, {"list", "01"}, {"list", "-1"}} {
+		if _, _, err := nd.Resolve(p); !errors.Is(err, ErrPathNotFound{}) {
+			t.Fatalf("%v: expected ErrPathNotFound, got %v", p, err)
+		}
+	}
+
+	lnk, rest, err := nd.ResolveLink([]string{"child", "leaf"})
+	if err != nil || !lnk.Cid.Equals(leaf.Cid()) || len(rest) != 0 {
+		t.Fatalf("unexpected link resolution: %v, %v, %v", lnk, rest, err)
+	}
+	if _, _, err := nd.ResolveLink([]string{"name"}); err == nil {
+		t.Fatal("expected an error resolving a non-link")
+	}
+
+	tree := nd.Tree("", -1)
+	wantTree := []string{"bytes", "child", "child/leaf", "count", "list", "list/0", "list/1", "list/2", "name"}
+	if !slices.Equal(tree, wantTree) {
+		t.Fatalf("unexpected tree %v", tree)
+	}
+	if tree := nd.Tree("", 1); !slices.Equal(tree, []string{"bytes", "child", "count", "list", "name"}) {
+		t.Fatalf("unexpected tree %v", tree)
+	}
+	if tree := nd.Tree("list", -1); !slices.Equal(tree, []string{"0", "1", "2"}) {
+		t.Fatalf("unexpected tree %v", tree)
+	}
+
+	links := nd.Links()
+	if len(links) != 2 || links[0].Name != "child/leaf" || links[1].Name != "list/2" {
+		t.Fatalf("unexpected links %v", links)
+	}
+	stat, err := nd.Stat()
+	if err != nil || stat.NumLinks != 2 || stat.BlockSize != len(want) {
+		t.Fatalf("unexpected stat %v: %v", stat, err)
+	}
+
+	// The node can't be modified through its value.
+	v := nd.Value().(map[string]interface{})
+	v["name"] = "changed"
+	if name, _, _ := nd.Resolve([]string{"name"}); name != "root" {
+		t.Fatal("modifying the value modified the node")
+	}
+}
+
+func TestDataNodeInvalid(t *testing.T) {
+	for _, v := range []interface{}{
+		1.5,
+		"\xff",
+		map[string]interface{}{"/": "x"},
+		map[string]interface{}{"/": "x", "y": 1},
+		map[string]interface{}{"a/b": "x"},
+		[]interface{}{struct{}{}},
+		cid.Undef,
+		uint64(math.MaxInt64) + 1,
+		uint(math.MaxUint),
+	} {
+		if _, err := NewDataNode(v); err == nil {
+			t.Fatalf("expected an error for %#v", v)
+		}
+	}
+}
+
+func TestDataNodeIntegers(t *testing.T) {
+	for _, v := range []interface{}{
+		int8(7), uint16(7), uint(7), uint64(7), uintptr(7),
+	} {
+		nd, err := NewDataNode(v)
+		if err != nil {
+			t.Fatalf("%T: %s", v, err)
+		}
+		if nd.value != int64(7) {
+			t.Fatalf("%T: unexpected value %#v", v, nd.value)
+		}
+	}
+
+	nd, err := NewDataNode(uint64(math.MaxInt64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nd.value != int64(math.MaxInt64) {
+		t.Fatalf("unexpected value %#v", nd.value)
+	}
+}
+
+func TestDecodeDataNode(t *testing.T) {
+	leaf := NewRawNode([]byte("leaf"))
+	nd, err := NewDataNode([]interface{}{"a", leaf.Cid(), []byte("b"), map[string]interface{}{"z": 1, "a": -2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reg Registry
+	RegisterDataNode(&reg)
+	blk, _ := blocks.NewBlockWithCid(nd.RawData(), nd.Cid())
+	decoded, err := reg.Decode(blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.(*DataNode).Value(), nd.Value()) || len(decoded.Links()) != 1 {
+		t.Fatalf("unexpected decoded node %s", decoded.RawData())
+	}
+
+	for _, raw := range []string{
+		`{"b":1,"a":2}`,
+		`{"a": 1}`,
+		`1.5`,
+		`[1] [2]`,
+		`{"/":"notacid"}`,
+		`{"a/b":1}`,
+		`{"/":{"bytes":"AAEC","x":1}}`,
+	} {
+		c, _ := cid.Prefix{Version: 1, Codec: DataNodeCodec, MhType: nd.Cid().Prefix().MhType, MhLength: -1}.Sum([]byte(raw))
+		blk, _ := blocks.NewBlockWithCid([]byte(raw), c)
+		if _, err := reg.Decode(blk); !errors.Is(err, ErrDecode{}) {
+			t.Fatalf("%s: expected ErrDecode, got %v", raw, err)
+		}
+	}
+}
+
+func TestDataNodeDAG(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	leaf, _ := NewDataNode(map[string]interface{}{"value": "leaf"})
+	mid, _ := NewDataNode(map[string]interface{}{"items": []interface{}{leaf.Cid()}})
+	root, _ := NewDataNode(map[string]interface{}{"mid": mid.Cid()})
+	for _, n := range []Node{leaf, mid, root} {
+		ds.Add(ctx, n)
+	}
+
+	res, err := ResolvePath(ctx, ds, root.Cid(), []string{"mid", "items", "0", "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Value != "leaf" || len(res.Cids) != 3 {
+		t.Fatalf("unexpected resolution %v", res.Value)
+	}
+
+	paths, err := collectTree(t, TreeDAG(ctx, ds, root.Cid(), -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(paths, []string{"mid", "mid/items", "mid/items/0", "mid/items/0/value"}) {
+		t.Fatalf("unexpected tree %v", paths)
+	}
+}
+
+func TestDataNodeEditLinks(t *testing.T) {
+	a := NewRawNode([]byte("a"))
+	b := NewRawNode([]byte("b"))
+	nd, err := NewDataNode(map[string]interface{}{
+		"list": []interface{}{a.Cid(), "x"},
+		"name": "node",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := nd.AddLink("sub/b", &Link{Cid: b.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err = added.(LinkEditable).AddLink("list/2", &Link{Cid: b.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(nd Node) []string {
+		var names []string
+		for _, l := range nd.Links() {
+			names = append(names, l.Name)
+		}
+		return names
+	}
+	if got := names(added); !slices.Equal(got, []string{"list/0", "list/2", "sub/b"}) {
+		t.Fatalf("unexpected links %v", got)
+	}
+	if got := names(nd); !slices.Equal(got, []string{"list/0"}) {
+		t.Fatalf("original node modified: %v", got)
+	}
+	if _, err := nd.AddLink("name", &Link{Cid: b.Cid()}); err == nil {
+		t.Fatal("expected an error adding an existing path")
+	}
+
+	replaced, err := nd.ReplaceLink("list/0", &Link{Cid: b.Cid()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lnk, _, _ := replaced.ResolveLink([]string{"list", "0"}); !lnk.Cid.Equals(b.Cid()) {
+		t.Fatalf("unexpected link %v", lnk)
+	}
+
+	removed, err := nd.RemoveLink("list/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := removed.Resolve([]string{"list"}); !reflect.DeepEqual(v, []interface{}{"x"}) {
+		t.Fatalf("unexpected list %v", v)
+	}
+
+	for _, name := range []string{"name", "list/1", "list/2", "nope"} {
+		if _, err := nd.RemoveLink(name); !errors.Is(err, ErrPathNotFound{}) {
+			t.Fatalf("%s: expected ErrPathNotFound, got %v", name, err)
+		}
+		if _, err := nd.ReplaceLink(name, &Link{Cid: b.Cid()}); !errors.Is(err, ErrPathNotFound{}) {
+			t.Fatalf("%s: expected ErrPathNotFound, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrLinkEditNotSupported is returned by the Editor when a node that has to
+// be modified doesn't implement LinkEditable.
+var ErrLinkEditNotSupported = errors.New("ipld: node does not support link editing")
+
+// LinkEditable is implemented by Nodes whose links can be edited. Nodes are
+// immutable, so every edit returns a new node, leaving the original one
+// untouched.
+//
+// Links are identified by their name, as returned by Links.
+type LinkEditable interface {
+	Node
+
+	// AddLink returns a copy of the node with a new link with the given
+	// name. It fails if the node already has a link with that name.
+	AddLink(name string, l *Link) (Node, error)
+
+	// RemoveLink returns a copy of the node without the link with the given
+	// name. It fails with an ErrPathNotFound if there is no such link.
+	RemoveLink(name string) (Node, error)
+
+	// ReplaceLink returns a copy of the node with the link with the given
+	// name pointing to the target of l instead. It fails with an
+	// ErrPathNotFound if there is no such link.
+	ReplaceLink(name string, l *Link) (Node, error)
+}
+
+// Editor makes copy-on-write edits to DAGs: changing a link deep in a DAG
+// rebuilds all of its ancestors, which are written to a NodeAdder, producing
+// a new root. The nodes along the edited path must implement LinkEditable.
+type Editor struct {
+	ng NodeGetter
+	na NodeAdder
+}
+
+// NewEditor creates an Editor reading nodes from ng and writing the ones it
+// creates to na.
+func NewEditor(ng NodeGetter, na NodeAdder) *Editor {
+	return &Editor{ng: ng, na: na}
+}
+
+// InsertNode makes the link at the given path, a list of link names
+// starting at root, point to the child node, adding the last link if it
+// doesn't exist yet. The child and the rebuilt ancestors are added to the
+// NodeAdder, and the new root is returned.
+//
+// With an empty path, the child simply becomes the new root.
+func (e *Editor) InsertNode(ctx context.Context, root cid.Cid, path []string, child Node) (Node, error) {
+	if len(path) == 0 {
+		if err := e.na.Add(ctx, child); err != nil {
+			return nil, err
+		}
+		return child, nil
+	}
+
+	lnk, err := MakeLink(child)
+	if err != nil {
+		return nil, err
+	}
+	return e.edit(ctx, root, path, []Node{child}, func(parent LinkEditable, name string) (Node, error) {
+		if findLink(parent, name) != nil {
+			return parent.ReplaceLink(name, lnk)
+		}
+		return parent.AddLink(name, lnk)
+	})
+}
+
+// RemoveLink removes the link at the given path, a list of link names
+// starting at root. The rebuilt ancestors are added to the NodeAdder, and
+// the new root is returned. The node the link pointed to is left in place.
+func (e *Editor) RemoveLink(ctx context.Context, root cid.Cid, path []string) (Node, error) {
+	if len(path) == 0 {
+		return nil, errors.New("ipld: cannot remove the root of a DAG")
+	}
+	return e.edit(ctx, root, path, nil, func(parent LinkEditable, name string) (Node, error) {
+		return parent.RemoveLink(name)
+	})
+}
+
+// edit fetches the nodes along path, applies fn to the parent of the last
+// link, rebuilds its ancestors and adds them (after the given new nodes) to
+// the NodeAdder.
+func (e *Editor) edit(ctx context.Context, root cid.Cid, path []string, added []Node, fn func(LinkEditable, string) (Node, error)) (Node, error) {
+	// nodes[i] is the node holding the link path[i].
+	nodes := make([]Node, len(path))
+	c := root
+	for i := range path {
+		nd, err := e.ng.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = nd
+		if i == len(path)-1 {
+			break
+		}
+		lnk := findLink(nd, path[i])
+		if lnk == nil {
+			return nil, ErrPathNotFound{Cid: c, Remaining: path[i:]}
+		}
+		c = lnk.Cid
+	}
+
+	var updated Node
+	for i := len(path) - 1; i >= 0; i-- {
+		parent, ok := nodes[i].(LinkEditable)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s (%T)", ErrLinkEditNotSupported, nodes[i].Cid(), nodes[i])
+		}
+
+		var err error
+		if updated == nil {
+			updated, err = fn(parent, path[i])
+		} else {
+			var lnk *Link
+			lnk, err = MakeLink(updated)
+			if err == nil {
+				updated, err = parent.ReplaceLink(path[i], lnk)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, updated)
+	}
+
+	if err := e.na.AddMany(ctx, added); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// findLink returns the link of nd with the given name, or nil.
+func findLink(nd Node, name string) *Link {
+	for _, l := range nd.Links() {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestEditor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	leaf, _ := NewDataNode("leaf")
+	mid, _ := NewDataNode(map[string]interface{}{"b": leaf.Cid(), "name": "mid"})
+	root, _ := NewDataNode(map[string]interface{}{"a": mid.Cid()})
+	for _, n := range []Node{leaf, mid, root} {
+		ds.Add(ctx, n)
+	}
+	e := NewEditor(ds, ds)
+
+	// Replace a link.
+	newLeaf, _ := NewDataNode("new leaf")
+	newRoot, err := e.InsertNode(ctx, root.Cid(), []string{"a", "b"}, newLeaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ResolvePath(ctx, ds, newRoot.Cid(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Node.Cid().Equals(newLeaf.Cid()) {
+		t.Fatalf("unexpected node %s", res.Node.Cid())
+	}
+	if name, err := ResolvePath(ctx, ds, newRoot.Cid(), []string{"a", "name"}); err != nil || name.Value != "mid" {
+		t.Fatalf("edits should keep the rest of the nodes: %v", err)
+	}
+	// The original DAG is untouched.
+	if res, err := ResolvePath(ctx, ds, root.Cid(), []string{"a", "b"}); err != nil || !res.Node.Cid().Equals(leaf.Cid()) {
+		t.Fatalf("original DAG modified: %v", err)
+	}
+
+	// Add a link.
+	newRoot, err = e.InsertNode(ctx, newRoot.Cid(), []string{"a", "c"}, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := collectTree(t, TreeDAG(ctx, ds, newRoot.Cid(), 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(paths, []string{"a", "a/b", "a/c", "a/name"}) {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+
+	// Remove it.
+	newRoot, err = e.RemoveLink(ctx, newRoot.Cid(), []string{"a", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolvePath(ctx, ds, newRoot.Cid(), []string{"a", "c"}); !errors.Is(err, ErrPathNotFound{}) {
+		t.Fatalf("expected ErrPathNotFound, got %v", err)
+	}
+
+	if _, err := e.InsertNode(ctx, root.Cid(), []string{"x", "b"}, leaf); !errors.Is(err, ErrPathNotFound{}) {
+		t.Fatalf("expected ErrPathNotFound, got %v", err)
+	}
+	if _, err := e.RemoveLink(ctx, root.Cid(), []string{"a", "x"}); !errors.Is(err, ErrPathNotFound{}) {
+		t.Fatalf("expected ErrPathNotFound, got %v", err)
+	}
+}
+
+func TestEditorNotEditable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	leaf := InitNode([]byte("leaf"))
+	root := InitNode([]byte("root"))
+	root.AddNodeLink("leaf", leaf)
+	ds.Add(ctx, leaf)
+	ds.Add(ctx, root)
+
+	_, err := NewEditor(ds, ds).InsertNode(ctx, root.Cid(), []string{"leaf"}, InitNode([]byte("other")))
+	if !errors.Is(err, ErrLinkEditNotSupported) {
+		t.Fatalf("expected ErrLinkEditNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package format
+
+import (
+	"context"
+	"slices"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// FillStats reports the work done by Fill.
+type FillStats struct {
+	// Reused is the number of blocks that were already present locally.
+	Reused int
+
+	// Fetched is the number of blocks fetched from the remote and written
+	// locally.
+	Fetched int
+}
+
+// Fill makes the DAG under root fully present in the local DAGService,
+// fetching the blocks it is missing from the remote NodeGetter.
+//
+// The DAG is walked breadth first using the local blocks where present: the
+// blocks missing from each chunk of a level (see walkLevels) are requested
+// from the remote with a single GetMany call and written locally through a
+// Batch. Subtrees shared by several parents are only visited once. Unlike
+// Copy, blocks already present are neither fetched nor rewritten.
+//
+// Remote blocks that don't match their CID fail with an ErrHashMismatch,
+// and blocks the remote doesn't have with an ErrNotFound. The blocks fetched
+// before a failure are still written.
+func Fill(ctx context.Context, root cid.Cid, local DAGService, remote NodeGetter) (FillStats, error) {
+	var stats FillStats
+	b := NewBatch(ctx, local)
+
+	err := walkLevels(ctx, root, func(ctx context.Context, chunk []levelEntry) (map[string][]*Link, error) {
+		keys := levelKeys(chunk)
+		found, err := getPresent(ctx, local, keys)
+		if err != nil {
+			return nil, err
+		}
+		stats.Reused += len(found)
+
+		var missing []cid.Cid
+		for _, k := range keys {
+			if _, ok := found[k.KeyString()]; !ok {
+				missing = append(missing, k)
+			}
+		}
+		if len(missing) == 0 {
+			return nodeLinks(found), nil
+		}
+
+		fetched, err := getPresent(ctx, remote, missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range missing {
+			nd, ok := fetched[k.KeyString()]
+			if !ok {
+				return nil, ErrNotFound{Cid: k}
+			}
+			if err := b.Add(ctx, nd); err != nil {
+				return nil, err
+			}
+			stats.Fetched++
+			found[k.KeyString()] = nd
+		}
+		return nodeLinks(found), nil
+	})
+
+	if cerr := b.Close(); err == nil {
+		err = cerr
+	}
+	return stats, err
+}
+
+// levelEntry is a node to visit in a level of walkLevels.
+type levelEntry struct {
+	Cid cid.Cid
+
+	// Parent and Name identify the link the node was first reached
+	// through. Parent is undefined for the root.
+	Parent cid.Cid
+	Name   string
+}
+
+// Number of entries of a level walkLevels fetches at once.
+const levelChunkSize = 256
+
+// walkLevels walks the DAG under root breadth first, a level at a time,
+// visiting every node once. The fetch function is called with every level,
+// in chunks of up to levelChunkSize entries, and returns the links of the
+// nodes found for the chunk, keyed by CID; those links make the next level
+// while missing nodes are skipped. Only the links are kept, not the nodes.
+func walkLevels(ctx context.Context, root cid.Cid, fetch func(context.Context, []levelEntry) (map[string][]*Link, error)) error {
+	visited := cid.NewSet()
+	visited.Add(root)
+	level := []levelEntry{{Cid: root}}
+	for len(level) > 0 {
+		var next []levelEntry
+		for chunk := range slices.Chunk(level, levelChunkSize) {
+			found, err := fetch(ctx, chunk)
+			if err != nil {
+				return err
+			}
+			for _, e := range chunk {
+				links, ok := found[e.Cid.KeyString()]
+				if !ok {
+					continue
+				}
+				for _, l := range links {
+					if visited.Visit(l.Cid) {
+						next = append(next, levelEntry{Cid: l.Cid, Parent: e.Cid, Name: l.Name})
+					}
+				}
+			}
+		}
+		level = next
+	}
+	return nil
+}
+
+// nodeLinks returns the links of the given nodes, keyed like them.
+func nodeLinks(nodes map[string]Node) map[string][]*Link {
+	links := make(map[string][]*Link, len(nodes))
+	for k, nd := range nodes {
+		links[k] = nd.Links()
+	}
+	return links
+}
+
+func levelKeys(level []levelEntry) []cid.Cid {
+	keys := make([]cid.Cid, len(level))
+	for i, e := range level {
+		keys[i] = e.Cid
+	}
+	return keys
+}
+
+// getPresent fetches the given keys with a single GetMany call and returns
+// the nodes found, keyed by CID. Keys that are not found are left out, any
+// other failure is returned, including nodes not matching their CID (see
+// checkNode).
+func getPresent(ctx context.Context, ng NodeGetter, keys []cid.Cid) (map[string]Node, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(map[string]Node, len(keys))
+	for opt := range GetManyKeyed(ctx, ng, keys) {
+		switch {
+		case IsNotFound(opt.Err):
+		case opt.Err != nil:
+			return nil, opt.Err
+		default:
+			if err := checkNode(opt.Cid, opt.Node); err != nil {
+				return nil, err
+			}
+			found[opt.Cid.KeyString()] = opt.Node
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
@@ -0,0 +1,135 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestFill(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	local := newTestDag()
+	remote := newTestDag()
+
+	shared, _ := NewDataNode("shared")
+	a, _ := NewDataNode(map[string]interface{}{"shared": shared.Cid(), "name": "a"})
+	b, _ := NewDataNode(map[string]interface{}{"shared": shared.Cid(), "name": "b"})
+	root, _ := NewDataNode(map[string]interface{}{"a": a.Cid(), "b": b.Cid()})
+	for _, n := range []Node{shared, a, b, root} {
+		remote.Add(ctx, n)
+	}
+	local.Add(ctx, root)
+	local.Add(ctx, a)
+
+	stats, err := Fill(ctx, root.Cid(), local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Reused != 2 || stats.Fetched != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	for _, n := range []Node{shared, a, b, root} {
+		if _, err := local.Get(ctx, n.Cid()); err != nil {
+			t.Fatalf("%s missing after fill: %s", n, err)
+		}
+	}
+
+	stats, err = Fill(ctx, root.Cid(), local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Reused != 4 || stats.Fetched != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestFillMissing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	local := newTestDag()
+	remote := newTestDag()
+
+	leaf, _ := NewDataNode("leaf")
+	missing, _ := NewDataNode("missing")
+	root, _ := NewDataNode([]interface{}{leaf.Cid(), missing.Cid()})
+	remote.Add(ctx, root)
+	remote.Add(ctx, leaf)
+
+	stats, err := Fill(ctx, root.Cid(), local, remote)
+	var notFound ErrNotFound
+	if !errors.As(err, &notFound) || !notFound.Cid.Equals(missing.Cid()) {
+		t.Fatalf("expected ErrNotFound for the missing node, got %v", err)
+	}
+	// What was fetched is kept.
+	if stats.Fetched != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if _, err := local.Get(ctx, root.Cid()); err != nil {
+		t.Fatalf("fetched root not written: %s", err)
+	}
+
+	// A remote returning the wrong data.
+	_, err = Fill(ctx, root.Cid(), newTestDag(), lyingGetter{leaf})
+	if !errors.Is(err, ErrHashMismatch{}) {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+
+	// A remote returning no node nor error.
+	nils := cid.NewSet()
+	nils.Add(root.Cid())
+	if _, err = Fill(ctx, root.Cid(), newTestDag(), nilGetter{remote, nils}); err == nil {
+		t.Fatal("expected a missing node to fail")
+	}
+}
+
+// addWideDAG adds to ds a root listing n leaves and returns them, root last.
+func addWideDAG(t *testing.T, ds DAGService, n int) []Node {
+	t.Helper()
+	nodes := make([]Node, 0, n+1)
+	links := make([]interface{}, n)
+	for i := range links {
+		leaf, err := NewDataNode(fmt.Sprint(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, leaf)
+		links[i] = leaf.Cid()
+	}
+	root, err := NewDataNode(links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes = append(nodes, root)
+	if err := ds.AddMany(context.Background(), nodes); err != nil {
+		t.Fatal(err)
+	}
+	return nodes
+}
+
+func TestFillChunks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	local := newTestDag()
+	remote := newTestDag()
+	nodes := addWideDAG(t, remote, 2*levelChunkSize+1)
+	root := nodes[len(nodes)-1]
+
+	g := &recordingGetter{NodeGetter: remote}
+	stats, err := Fill(ctx, root.Cid(), local, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Fetched != len(nodes) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if g.maxBatch > levelChunkSize {
+		t.Fatalf("expected at most %d blocks fetched at once, got %d", levelChunkSize, g.maxBatch)
+	}
+	if len(local.nodes) != len(nodes) {
+		t.Fatalf("expected %d blocks written, got %d", len(nodes), len(local.nodes))
+	}
+}
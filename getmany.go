@@ -0,0 +1,159 @@
+package format
+
+import (
+	"context"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// DefaultGetManyParallelism is the number of concurrent Get calls made by
+// the GetMany method of a NodeGetterFunc.
+const DefaultGetManyParallelism = 8
+
+// NodeGetterFunc adapts a Get function into a NodeGetter, for stores that
+// can only fetch one node at a time. Its GetMany method fetches the nodes
+// with GetManyFromGet, using DefaultGetManyParallelism concurrent calls.
+type NodeGetterFunc func(ctx context.Context, c cid.Cid) (Node, error)
+
+var _ NodeGetter = NodeGetterFunc(nil)
+
+// Get calls f.
+func (f NodeGetterFunc) Get(ctx context.Context, c cid.Cid) (Node, error) {
+	return f(ctx, c)
+}
+
+// GetMany fetches the given nodes calling f concurrently, see
+// GetManyFromGet. Nodes are returned as they are fetched.
+func (f NodeGetterFunc) GetMany(ctx context.Context, cids []cid.Cid) <-chan *NodeOption {
+	return GetManyFromGet(ctx, f, cids, DefaultGetManyParallelism)
+}
+
+// GetManyOption provides a way of customizing GetManyFromGet.
+type GetManyOption func(o *getManyOptions)
+
+type getManyOptions struct {
+	ordered bool
+}
+
+// OrderedGetManyOption makes GetManyFromGet return the nodes in the order
+// they were requested instead of as soon as they are fetched. Nodes fetched
+// early are then buffered until the ones before them are returned.
+func OrderedGetManyOption() GetManyOption {
+	return func(o *getManyOptions) {
+		o.ordered = true
+	}
+}
+
+// GetManyFromGet implements NodeGetter.GetMany on top of a Get function,
+// calling it for every requested CID with at most `parallelism` concurrent
+// calls (values lower than one are taken as one). Duplicate CIDs are only
+// fetched and returned once.
+//
+// Every NodeOption sent on the returned channel carries the CID it was
+// requested for and the outcome of the Get call. The channel is closed once
+// all the nodes have been returned or the context is canceled, in which
+// case no further Get calls are made.
+func GetManyFromGet(ctx context.Context, get NodeGetterFunc, cids []cid.Cid, parallelism int, opts ...GetManyOption) <-chan *NodeOption {
+	var o getManyOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	keys := make([]cid.Cid, 0, len(cids))
+	seen := cid.NewSet()
+	for _, c := range cids {
+		if seen.Visit(c) {
+			keys = append(keys, c)
+		}
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range keys {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	type result struct {
+		index int
+		opt   *NodeOption
+	}
+	out := make(chan *NodeOption)
+	results := make(chan result)
+
+	// send passes the result for keys[i] along, in order or not.
+	send := func(i int, opt *NodeOption) bool {
+		if o.ordered {
+			select {
+			case results <- result{i, opt}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		select {
+		case out <- opt:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range min(max(parallelism, 1), len(keys)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				nd, err := get(ctx, keys[i])
+				if !send(i, &NodeOption{Node: nd, Err: err, Cid: keys[i]}) {
+					return
+				}
+			}
+		}()
+	}
+
+	if !o.ordered {
+		go func() {
+			wg.Wait()
+			close(out)
+		}()
+		return out
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	go func() {
+		defer close(out)
+		pending := make(map[int]*NodeOption)
+		next := 0
+		for r := range results {
+			pending[r.index] = r.opt
+			for {
+				opt, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				select {
+				case out <- opt:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
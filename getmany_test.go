@@ -0,0 +1,121 @@
+package format
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestGetManyFromGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	var keys []cid.Cid
+	for i := 0; i < 20; i++ {
+		nd := NewRawNode([]byte{byte(i)})
+		ds.Add(ctx, nd)
+		keys = append(keys, nd.Cid())
+	}
+	missing := NewRawNode([]byte("missing")).Cid()
+	requested := append(append([]cid.Cid{missing}, keys...), keys[:5]...)
+
+	var calls, active, maxActive atomic.Int32
+	get := func(ctx context.Context, c cid.Cid) (Node, error) {
+		calls.Add(1)
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		// Vary the time each fetch takes, to mix up the order.
+		time.Sleep(time.Duration(len(keys)-int(c.Bytes()[len(c.Bytes())-1]%20)) * 100 * time.Microsecond)
+		return ds.Get(ctx, c)
+	}
+
+	for _, ordered := range []bool{false, true} {
+		calls.Store(0)
+		maxActive.Store(0)
+		var opts []GetManyOption
+		if ordered {
+			opts = append(opts, OrderedGetManyOption())
+		}
+
+		var got []cid.Cid
+		for opt := range GetManyFromGet(ctx, get, requested, 4, opts...) {
+			if opt.Cid.Equals(missing) {
+				if !IsNotFound(opt.Err) {
+					t.Fatalf("expected ErrNotFound, got %v", opt.Err)
+				}
+			} else if opt.Err != nil || !opt.Node.Cid().Equals(opt.Cid) {
+				t.Fatalf("unexpected option %+v", opt)
+			}
+			got = append(got, opt.Cid)
+		}
+
+		if len(got) != len(keys)+1 || int(calls.Load()) != len(keys)+1 {
+			t.Fatalf("expected %d deduplicated results, got %d after %d calls", len(keys)+1, len(got), calls.Load())
+		}
+		if maxActive.Load() > 4 {
+			t.Fatalf("expected at most 4 concurrent calls, got %d", maxActive.Load())
+		}
+		if ordered {
+			for i, c := range got {
+				if !c.Equals(requested[i]) {
+					t.Fatalf("result %d out of order", i)
+				}
+			}
+		}
+	}
+}
+
+func TestGetManyFromGetCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var keys []cid.Cid
+	for i := 0; i < 10; i++ {
+		keys = append(keys, NewRawNode([]byte{byte(i)}).Cid())
+	}
+	var calls atomic.Int32
+	var once sync.Once
+	get := func(ctx context.Context, c cid.Cid) (Node, error) {
+		calls.Add(1)
+		once.Do(cancel)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	for _, opts := range [][]GetManyOption{nil, {OrderedGetManyOption()}} {
+		for range GetManyFromGet(ctx, get, keys, 2, opts...) {
+		}
+	}
+	if calls.Load() > 2 {
+		t.Fatalf("expected no calls after cancellation, got %d", calls.Load())
+	}
+}
+
+func TestNodeGetterFunc(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	a, b := NewRawNode([]byte("a")), NewRawNode([]byte("b"))
+	ds.Add(ctx, a)
+	ds.Add(ctx, b)
+
+	ng := NodeGetterFunc(ds.Get)
+	nodes, err := NewNodePromiseGroup(GetNodes(ctx, ng, []cid.Cid{a.Cid(), b.Cid(), a.Cid()})).WaitAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nodes[0].Cid().Equals(a.Cid()) || !nodes[1].Cid().Equals(b.Cid()) || !nodes[2].Cid().Equals(a.Cid()) {
+		t.Fatalf("unexpected nodes %v", nodes)
+	}
+}
@@ -0,0 +1,349 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// Conflict is a change made differently in two versions of a DAG that Merge
+// can't reconcile on its own: the link at Path (a list of link names from
+// the root) points to different nodes in Ours and Theirs, and either the
+// change can't be merged at link level or both sides changed the data of
+// the node itself.
+//
+// Base, Ours and Theirs are undefined when the link is missing in that
+// version. An empty Path refers to the root nodes.
+type Conflict struct {
+	Path   []string
+	Base   cid.Cid
+	Ours   cid.Cid
+	Theirs cid.Cid
+}
+
+// ConflictResolver resolves a Conflict found by Merge, returning the CID the
+// link should point to in the merged DAG, or cid.Undef to drop it. It may be
+// one of the conflicting versions or a new node, which Merge fetches from
+// the NodeGetter it was passed to build the link: the resolver must make it
+// readable from there (e.g., by adding it to a DAGService used as both the
+// NodeGetter and the NodeAdder).
+type ConflictResolver func(ctx context.Context, c Conflict) (cid.Cid, error)
+
+// ErrMergeConflict is returned by Merge when no ConflictResolver is given
+// and the versions conflict. It lists all the conflicts found.
+type ErrMergeConflict struct {
+	Conflicts []Conflict
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrMergeConflict) Error() string {
+	paths := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		paths[i] = "/" + strings.Join(c.Path, "/")
+	}
+	return fmt.Sprintf("ipld: merge conflicts at %s", strings.Join(paths, ", "))
+}
+
+// Is allows to check whether any error is of this ErrMergeConflict type.
+// Do not use this directly, but rather errors.Is(yourError, ErrMergeConflict{}).
+func (e ErrMergeConflict) Is(err error) bool {
+	_, ok := err.(ErrMergeConflict)
+	return ok
+}
+
+// Merge performs a three-way merge of two versions of a DAG, ours and
+// theirs, forked from a common base, and returns the root of the merged DAG.
+//
+// The versions are walked in parallel, following links by name and skipping
+// the subtrees that are identical (by CID) or only changed on one side. When
+// a node changed on both sides, the changes to its links are merged (which
+// requires it to implement LinkEditable), as long as at most one side
+// changed the rest of its data. Anything else is a Conflict, which is
+// passed to the resolve callback. If it is nil, Merge fails with an
+// ErrMergeConflict listing every conflict instead.
+//
+// The nodes created are added to the NodeAdder.
+func Merge(ctx context.Context, ng NodeGetter, na NodeAdder, base, ours, theirs cid.Cid, resolve ConflictResolver) (cid.Cid, error) {
+	m := &merger{
+		ng:      ng,
+		resolve: resolve,
+		limits:  newTraversalLimits(nil),
+	}
+	root, err := m.merge(ctx, nil, &Link{Cid: base}, &Link{Cid: ours}, &Link{Cid: theirs})
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(m.conflicts) > 0 {
+		return cid.Undef, ErrMergeConflict{Conflicts: m.conflicts}
+	}
+	if root == nil {
+		return cid.Undef, errors.New("ipld: merge removed the root")
+	}
+	if err := na.AddMany(ctx, m.added); err != nil {
+		return cid.Undef, err
+	}
+	return root.Cid, nil
+}
+
+type merger struct {
+	ng      NodeGetter
+	resolve ConflictResolver
+	limits  *traversalLimits
+
+	// Nodes created, children first.
+	added []Node
+
+	// Conflicts found, when there's no resolver.
+	conflicts []Conflict
+
+	// CIDs of our nodes from the root to the one being merged.
+	ancestors []cid.Cid
+}
+
+// merge merges the link at path in the three versions, any of which may be
+// nil if missing, and returns the merged link, or nil if it was removed.
+func (m *merger) merge(ctx context.Context, path []string, base, ours, theirs *Link) (*Link, error) {
+	b, o, t := linkCid(base), linkCid(ours), linkCid(theirs)
+	switch {
+	case o.Equals(t):
+		return ours, nil
+	case b.Equals(o):
+		return theirs, nil
+	case b.Equals(t):
+		return ours, nil
+	case !b.Defined() || !o.Defined() || !t.Defined():
+		// Added differently on both sides, or removed on one side and
+		// modified on the other.
+		return m.conflict(ctx, path, base, ours, theirs)
+	}
+
+	if err := m.limits.enter(len(m.ancestors), o, slices.Values(m.ancestors)); err != nil {
+		return nil, err
+	}
+	nodes, err := NewNodePromiseGroup(GetNodes(ctx, m.ng, []cid.Cid{b, o, t})).WaitAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bn, bok := nodes[0].(LinkEditable)
+	on, ook := nodes[1].(LinkEditable)
+	tn, tok := nodes[2].(LinkEditable)
+	if !bok || !ook || !tok {
+		return m.conflict(ctx, path, base, ours, theirs)
+	}
+
+	// Nodes where the links are all that changed are rebuilt from base, so
+	// the one (if any) that changed something else is the starting point.
+	oursData := !sameData(bn, on)
+	theirsData := !sameData(bn, tn)
+	into := bn
+	switch {
+	case oursData && theirsData:
+		return m.conflict(ctx, path, base, ours, theirs)
+	case oursData:
+		into = on
+	case theirsData:
+		into = tn
+	}
+
+	bl, err := linksByName(bn)
+	if err != nil {
+		return m.conflict(ctx, path, base, ours, theirs)
+	}
+	ol, err := linksByName(on)
+	if err != nil {
+		return m.conflict(ctx, path, base, ours, theirs)
+	}
+	tl, err := linksByName(tn)
+	if err != nil {
+		return m.conflict(ctx, path, base, ours, theirs)
+	}
+
+	m.ancestors = append(m.ancestors, o)
+	merged := make(map[string]*Link)
+	for _, name := range linkNames(bn, on, tn) {
+		lnk, err := m.merge(ctx, append(slices.Clip(path), name), bl[name], ol[name], tl[name])
+		if err != nil {
+			return nil, err
+		}
+		if lnk != nil {
+			merged[name] = lnk
+		}
+	}
+	m.ancestors = m.ancestors[:len(m.ancestors)-1]
+
+	nd, err := applyLinks(into, merged)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case nd.Cid().Equals(o):
+		return ours, nil
+	case nd.Cid().Equals(t):
+		return theirs, nil
+	}
+	m.added = append(m.added, nd)
+	return MakeLink(nd)
+}
+
+// conflict resolves a conflict with the resolver, or records it.
+func (m *merger) conflict(ctx context.Context, path []string, base, ours, theirs *Link) (*Link, error) {
+	c := Conflict{
+		Path:   path,
+		Base:   linkCid(base),
+		Ours:   linkCid(ours),
+		Theirs: linkCid(theirs),
+	}
+	if m.resolve == nil {
+		m.conflicts = append(m.conflicts, c)
+		return ours, nil
+	}
+
+	res, err := m.resolve(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range []*Link{base, ours, theirs} {
+		if res.Equals(linkCid(l)) {
+			return l, nil
+		}
+	}
+	if !res.Defined() {
+		return nil, nil
+	}
+	nd, err := m.ng.Get(ctx, res)
+	if err != nil {
+		return nil, err
+	}
+	return MakeLink(nd)
+}
+
+func linkCid(l *Link) cid.Cid {
+	if l == nil {
+		return cid.Undef
+	}
+	return l.Cid
+}
+
+// linksByName indexes the links of a node by name, failing if several
+// links share a name.
+func linksByName(nd Node) (map[string]*Link, error) {
+	links := make(map[string]*Link)
+	for _, l := range nd.Links() {
+		if _, ok := links[l.Name]; ok {
+			return nil, fmt.Errorf("ipld: duplicate link %q in %s", l.Name, nd.Cid())
+		}
+		links[l.Name] = l
+	}
+	return links, nil
+}
+
+// linkNames returns the names of the links of all the given nodes, in order
+// of appearance and without duplicates.
+func linkNames(nds ...Node) []string {
+	var names []string
+	seen := make(map[string]struct{})
+	for _, nd := range nds {
+		for _, l := range nd.Links() {
+			if _, ok := seen[l.Name]; !ok {
+				seen[l.Name] = struct{}{}
+				names = append(names, l.Name)
+			}
+		}
+	}
+	return names
+}
+
+// sameData returns whether nd only differs from base in its links, by
+// applying the link changes to base and comparing the result.
+func sameData(base LinkEditable, nd Node) bool {
+	links, err := linksByName(nd)
+	if err != nil {
+		return false
+	}
+	edited, err := applyLinks(base, links)
+	return err == nil && edited.Cid().Equals(nd.Cid())
+}
+
+// applyLinks edits the links of nd so they are exactly the given ones.
+// Links are replaced, then added and then removed last to first, so the
+// names of the links of nodes keeping them in lists stay valid.
+func applyLinks(nd LinkEditable, links map[string]*Link) (Node, error) {
+	current := nd.Links()
+	var (
+		cur     Node = nd
+		err     error
+		removed []string
+	)
+	// edit applies fn to the current node, unless an edit failed already.
+	edit := func(fn func(LinkEditable) (Node, error)) {
+		if err != nil {
+			return
+		}
+		le, ok := cur.(LinkEditable)
+		if !ok {
+			err = fmt.Errorf("%w: %s (%T)", ErrLinkEditNotSupported, cur.Cid(), cur)
+			return
+		}
+		cur, err = fn(le)
+	}
+
+	existing := make(map[string]struct{})
+	for _, l := range current {
+		existing[l.Name] = struct{}{}
+		want, ok := links[l.Name]
+		switch {
+		case !ok:
+			removed = append(removed, l.Name)
+		case !want.Cid.Equals(l.Cid):
+			edit(func(le LinkEditable) (Node, error) { return le.ReplaceLink(l.Name, want) })
+		}
+	}
+	for _, name := range sortedLinkNames(links) {
+		if _, ok := existing[name]; !ok {
+			edit(func(le LinkEditable) (Node, error) { return le.AddLink(name, links[name]) })
+		}
+	}
+	for _, name := range slices.Backward(removed) {
+		edit(func(le LinkEditable) (Node, error) { return le.RemoveLink(name) })
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cur, nil
+}
+
+// sortedLinkNames returns the names in links sorted by their segments,
+// comparing numeric segments (list indexes) by value.
+func sortedLinkNames(links map[string]*Link) []string {
+	names := make([]string, 0, len(links))
+	for name := range links {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+		for i := 0; i < len(as) && i < len(bs); i++ {
+			if len(as[i]) != len(bs[i]) && isIndex(as[i]) && isIndex(bs[i]) {
+				return len(as[i]) - len(bs[i])
+			}
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+		return len(as) - len(bs)
+	})
+	return names
+}
+
+func isIndex(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,163 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// mergeFixture adds DataNodes to a testDag.
+type mergeFixture struct {
+	t  *testing.T
+	ds *testDag
+}
+
+func (f *mergeFixture) node(v interface{}) cid.Cid {
+	f.t.Helper()
+	nd, err := NewDataNode(v)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.ds.Add(context.Background(), nd)
+	return nd.Cid()
+}
+
+func (f *mergeFixture) value(c cid.Cid) interface{} {
+	f.t.Helper()
+	nd, err := f.ds.Get(context.Background(), c)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return nd.(*DataNode).Value()
+}
+
+func TestMerge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := &mergeFixture{t: t, ds: newTestDag()}
+
+	a0, a1 := f.node("a0"), f.node("a1")
+	b0, b1 := f.node("b0"), f.node("b1")
+	c0, d0 := f.node("c0"), f.node("d0")
+	x0, x1, x2 := f.node("x0"), f.node("x1"), f.node("x2")
+	y0, y1 := f.node("y0"), f.node("y1")
+
+	base := f.node(map[string]interface{}{
+		"name": "base",
+		"a":    a0,
+		"b":    b0,
+		"c":    c0,
+		"dir":  f.node(map[string]interface{}{"x": x0, "y": y0}),
+	})
+	// Ours changes the name, a and dir/x, and adds d.
+	ours := f.node(map[string]interface{}{
+		"name": "ours",
+		"a":    a1,
+		"b":    b0,
+		"c":    c0,
+		"d":    d0,
+		"dir":  f.node(map[string]interface{}{"x": x1, "y": y0}),
+	})
+	// Theirs changes b and dir/y, and removes c.
+	theirs := f.node(map[string]interface{}{
+		"name": "base",
+		"a":    a0,
+		"b":    b1,
+		"dir":  f.node(map[string]interface{}{"x": x0, "y": y1}),
+	})
+
+	merged, err := Merge(ctx, f.ds, f.ds, base, ours, theirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name": "ours",
+		"a":    a1,
+		"b":    b1,
+		"d":    d0,
+		"dir":  f.node(map[string]interface{}{"x": x1, "y": y1}),
+	}
+	if got := f.value(merged); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected merge:\n%v\nexpected:\n%v", got, want)
+	}
+
+	// Merging with an unchanged side returns the other one.
+	if merged, err := Merge(ctx, f.ds, f.ds, base, base, theirs, nil); err != nil || !merged.Equals(theirs) {
+		t.Fatalf("expected theirs, got %s: %v", merged, err)
+	}
+
+	// Conflicting changes of dir/x and of the name.
+	conflicting := f.node(map[string]interface{}{
+		"name": "conflicting",
+		"a":    a0,
+		"b":    b0,
+		"c":    c0,
+		"dir":  f.node(map[string]interface{}{"x": x2, "y": y0}),
+	})
+	_, err = Merge(ctx, f.ds, f.ds, base, ours, conflicting, nil)
+	var conflictErr ErrMergeConflict
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ErrMergeConflict, got %v", err)
+	}
+	if len(conflictErr.Conflicts) != 1 || len(conflictErr.Conflicts[0].Path) != 0 {
+		t.Fatalf("expected a conflict on the root, got %v", conflictErr.Conflicts)
+	}
+
+	// Conflicting changes of dir/x only, resolved with theirs.
+	conflicting = f.node(map[string]interface{}{
+		"name": "base",
+		"a":    a0,
+		"b":    b0,
+		"c":    c0,
+		"dir":  f.node(map[string]interface{}{"x": x2, "y": y0}),
+	})
+	var conflicts []Conflict
+	merged, err = Merge(ctx, f.ds, f.ds, base, ours, conflicting, func(_ context.Context, c Conflict) (cid.Cid, error) {
+		conflicts = append(conflicts, c)
+		return c.Theirs, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || !slices.Equal(conflicts[0].Path, []string{"dir", "x"}) ||
+		!conflicts[0].Base.Equals(x0) || !conflicts[0].Ours.Equals(x1) || !conflicts[0].Theirs.Equals(x2) {
+		t.Fatalf("unexpected conflicts %v", conflicts)
+	}
+	res, err := ResolvePath(ctx, f.ds, merged, []string{"dir", "x"})
+	if err != nil || res.Value.(*DataNode).Cid() != x2 {
+		t.Fatalf("expected the conflict to be resolved with theirs: %v", err)
+	}
+}
+
+func TestMergeRemovedAndModified(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := &mergeFixture{t: t, ds: newTestDag()}
+
+	a0, a1 := f.node("a0"), f.node("a1")
+	base := f.node(map[string]interface{}{"a": a0})
+	ours := f.node(map[string]interface{}{"a": a1})
+	theirs := f.node(map[string]interface{}{})
+
+	_, err := Merge(ctx, f.ds, f.ds, base, ours, theirs, nil)
+	var conflictErr ErrMergeConflict
+	if !errors.As(err, &conflictErr) || len(conflictErr.Conflicts) != 1 {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	c := conflictErr.Conflicts[0]
+	if !slices.Equal(c.Path, []string{"a"}) || !c.Ours.Equals(a1) || c.Theirs.Defined() {
+		t.Fatalf("unexpected conflict %v", c)
+	}
+
+	// Resolve by removing the link.
+	merged, err := Merge(ctx, f.ds, f.ds, base, ours, theirs, func(context.Context, Conflict) (cid.Cid, error) {
+		return cid.Undef, nil
+	})
+	if err != nil || !merged.Equals(theirs) {
+		t.Fatalf("expected theirs, got %s: %v", merged, err)
+	}
+}
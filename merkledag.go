@@ -3,6 +3,8 @@ package format
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 
 	cid "github.com/ipfs/go-cid"
 )
@@ -48,10 +50,250 @@ func IsNotFound(err error) bool {
 	return errors.Is(err, ErrNotFound{})
 }
 
+// ErrCycleDetected is returned by DAG traversals when a node links back to
+// one of its own ancestors. Merkle DAGs can't contain cycles, so this signals
+// a buggy or malicious NodeGetter.
+//
+// The Cid field holds the CID of the node that was reached again.
+type ErrCycleDetected struct {
+	Cid cid.Cid
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrCycleDetected) Error() string {
+	return "ipld: cycle detected at " + e.Cid.String()
+}
+
+// Is allows to check whether any error is of this ErrCycleDetected type.
+// Do not use this directly, but rather errors.Is(yourError, ErrCycleDetected{}).
+func (e ErrCycleDetected) Is(err error) bool {
+	_, ok := err.(ErrCycleDetected)
+	return ok
+}
+
+// ErrMaxDepthExceeded is returned by DAG traversals configured with
+// MaxDepthTraversalOption when a node deeper than the limit is reached.
+type ErrMaxDepthExceeded struct {
+	MaxDepth int
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrMaxDepthExceeded) Error() string {
+	return fmt.Sprintf("ipld: maximum traversal depth of %d exceeded", e.MaxDepth)
+}
+
+// Is allows to check whether any error is of this ErrMaxDepthExceeded type.
+// Do not use this directly, but rather errors.Is(yourError, ErrMaxDepthExceeded{}).
+func (e ErrMaxDepthExceeded) Is(err error) bool {
+	_, ok := err.(ErrMaxDepthExceeded)
+	return ok
+}
+
+// ErrMaxNodesExceeded is returned by DAG traversals configured with
+// MaxNodesTraversalOption when more nodes than the limit would be visited.
+type ErrMaxNodesExceeded struct {
+	MaxNodes int
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrMaxNodesExceeded) Error() string {
+	return fmt.Sprintf("ipld: maximum of %d traversed nodes exceeded", e.MaxNodes)
+}
+
+// Is allows to check whether any error is of this ErrMaxNodesExceeded type.
+// Do not use this directly, but rather errors.Is(yourError, ErrMaxNodesExceeded{}).
+func (e ErrMaxNodesExceeded) Is(err error) bool {
+	_, ok := err.(ErrMaxNodesExceeded)
+	return ok
+}
+
+// ErrDecode is returned when a block can't be decoded into a Node, either
+// because there is no decoder for its codec or because the decoder failed.
+// It is a permanent failure: retrying will not fix it.
+type ErrDecode struct {
+	Cid   cid.Cid
+	Codec uint64
+	Cause error
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrDecode) Error() string {
+	return fmt.Sprintf("ipld: could not decode %s (codec 0x%x): %s", e.Cid, e.Codec, e.Cause)
+}
+
+// Unwrap returns the underlying cause of this error.
+func (e ErrDecode) Unwrap() error {
+	return e.Cause
+}
+
+// Is allows to check whether any error is of this ErrDecode type.
+// Do not use this directly, but rather errors.Is(yourError, ErrDecode{}).
+func (e ErrDecode) Is(err error) bool {
+	_, ok := err.(ErrDecode)
+	return ok
+}
+
+// ErrHashMismatch is returned when the data received for the CID Cid
+// actually hashes to the CID Got. It is a permanent failure: retrying against
+// the same source will not fix it.
+type ErrHashMismatch struct {
+	Cid cid.Cid
+	Got cid.Cid
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrHashMismatch) Error() string {
+	return fmt.Sprintf("ipld: hash mismatch: requested %s, got %s", e.Cid, e.Got)
+}
+
+// Is allows to check whether any error is of this ErrHashMismatch type.
+// Do not use this directly, but rather errors.Is(yourError, ErrHashMismatch{}).
+func (e ErrHashMismatch) Is(err error) bool {
+	_, ok := err.(ErrHashMismatch)
+	return ok
+}
+
+// ErrPathNotFound is returned when the Remaining part of a path can't be
+// resolved within the node with the given Cid. It is a permanent failure.
+type ErrPathNotFound struct {
+	Cid       cid.Cid
+	Remaining []string
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrPathNotFound) Error() string {
+	return fmt.Sprintf("ipld: could not resolve %q in %s", strings.Join(e.Remaining, "/"), e.Cid)
+}
+
+// Is allows to check whether any error is of this ErrPathNotFound type.
+// Do not use this directly, but rather errors.Is(yourError, ErrPathNotFound{}).
+func (e ErrPathNotFound) Is(err error) bool {
+	_, ok := err.(ErrPathNotFound)
+	return ok
+}
+
+// ErrResolve is returned by ResolvePath when a path can't be resolved. It
+// points at the segment of the path that failed and wraps the cause: an
+// ErrPathNotFound when the path doesn't exist in the DAG, the error returned
+// by the NodeGetter when a node couldn't be fetched, or the one returned by
+// a node that failed to resolve it.
+type ErrResolve struct {
+	Path []string
+
+	// Segment is the index of the first segment of Path that could not be
+	// resolved. It is len(Path) if all of them were resolved but the node
+	// they lead to couldn't be fetched.
+	Segment int
+
+	Cause error
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrResolve) Error() string {
+	return fmt.Sprintf("ipld: could not resolve %q past %q: %s",
+		strings.Join(e.Path, "/"), strings.Join(e.Path[:e.Segment], "/"), e.Cause)
+}
+
+// Unwrap returns the underlying cause of this error.
+func (e ErrResolve) Unwrap() error {
+	return e.Cause
+}
+
+// Is allows to check whether any error is of this ErrResolve type.
+// Do not use this directly, but rather errors.Is(yourError, ErrResolve{}).
+func (e ErrResolve) Is(err error) bool {
+	_, ok := err.(ErrResolve)
+	return ok
+}
+
+// ErrBatchCommit is returned by a Batch when committing some of its nodes
+// to the underlying NodeAdder failed. Failed lists the CIDs of the nodes of
+// the failed commit; whether retrying makes sense depends on the Cause.
+type ErrBatchCommit struct {
+	Failed []cid.Cid
+	Cause  error
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrBatchCommit) Error() string {
+	return fmt.Sprintf("ipld: failed to commit %d nodes: %s", len(e.Failed), e.Cause)
+}
+
+// Unwrap returns the underlying cause of this error.
+func (e ErrBatchCommit) Unwrap() error {
+	return e.Cause
+}
+
+// Is allows to check whether any error is of this ErrBatchCommit type.
+// Do not use this directly, but rather errors.Is(yourError, ErrBatchCommit{}).
+func (e ErrBatchCommit) Is(err error) bool {
+	_, ok := err.(ErrBatchCommit)
+	return ok
+}
+
+// ErrBatchPending is returned by a Batch when it accepted some operations
+// but could not commit them as required, typically because the context
+// expired while waiting for a commit slot. The operations stay batched and
+// are committed later like any other; only the Cause should be handled.
+type ErrBatchPending struct {
+	Cause error
+}
+
+// Error implements the error interface and returns a human-readable
+// message for this error.
+func (e ErrBatchPending) Error() string {
+	return fmt.Sprintf("ipld: operations batched but not committed yet: %s", e.Cause)
+}
+
+// Unwrap returns the underlying cause of this error.
+func (e ErrBatchPending) Unwrap() error {
+	return e.Cause
+}
+
+// Is allows to check whether any error is of this ErrBatchPending type.
+// Do not use this directly, but rather errors.Is(yourError, ErrBatchPending{}).
+func (e ErrBatchPending) Is(err error) bool {
+	_, ok := err.(ErrBatchPending)
+	return ok
+}
+
+// IsPermanent returns whether the given error is or wraps an error that
+// retrying the same operation can't fix (a decoding or hash failure, an
+// unresolvable path, a cycle or an exceeded traversal limit). Any other
+// error, including ErrNotFound, may be transient.
+func IsPermanent(err error) bool {
+	for _, target := range []error{
+		ErrDecode{},
+		ErrHashMismatch{},
+		ErrPathNotFound{},
+		ErrCycleDetected{},
+		ErrMaxDepthExceeded{},
+		ErrMaxNodesExceeded{},
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // Either a node or an error.
 type NodeOption struct {
 	Node Node
 	Err  error
+
+	// Cid is the requested CID this option refers to. NodeGetter
+	// implementations should set it, but older ones may leave it undefined;
+	// use GetManyKeyed to always have it filled in when possible.
+	Cid cid.Cid
 }
 
 // The basic Node resolution service.
@@ -62,6 +304,8 @@ type NodeGetter interface {
 	Get(context.Context, cid.Cid) (Node, error)
 
 	// GetMany returns a channel of NodeOptions given a set of CIDs.
+	// Implementations should set the Cid of every NodeOption to the
+	// requested CID it corresponds to.
 	GetMany(context.Context, []cid.Cid) <-chan *NodeOption
 }
 
@@ -89,11 +333,8 @@ type LinkGetter interface {
 	GetLinks(ctx context.Context, nd cid.Cid) ([]*Link, error)
 }
 
-// DAGService is an IPFS Merkle DAG service.
-type DAGService interface {
-	NodeGetter
-	NodeAdder
-
+// NodeRemover removes nodes from a DAG.
+type NodeRemover interface {
 	// Remove removes a node from this DAG.
 	//
 	// Remove returns no error if the requested node is not present in this DAG.
@@ -102,5 +343,15 @@ type DAGService interface {
 	// RemoveMany removes many nodes from this DAG.
 	//
 	// It returns success even if the nodes were not present in the DAG.
+	//
+	// Consider using the Batch (`NewBatch`) if you make extensive use of
+	// this function alongside adds.
 	RemoveMany(context.Context, []cid.Cid) error
 }
+
+// DAGService is an IPFS Merkle DAG service.
+type DAGService interface {
+	NodeGetter
+	NodeAdder
+	NodeRemover
+}
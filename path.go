@@ -0,0 +1,180 @@
+package format
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ErrInvalidPath is wrapped by the errors returned when parsing a malformed
+// path.
+var ErrInvalidPath = errors.New("invalid path")
+
+// Path namespaces accepted by ParsePath.
+const (
+	IPFSNamespace = "ipfs"
+	IPLDNamespace = "ipld"
+)
+
+// relativePrefix marks a path as relative regardless of its first segment.
+const relativePrefix = "./"
+
+// Path is a parsed IPLD path: an optional namespace and root CID followed
+// by the segments to resolve from that root, in the form the Resolver
+// methods and ResolvePath expect them.
+//
+// In their string form, segments are separated by slashes. Segments
+// containing slashes (or percent signs) are percent-encoded, so every
+// segment can be represented except for the empty one. Relative paths whose
+// first segment would be read as a root (or as this prefix) are prefixed
+// with "./".
+type Path struct {
+	// Namespace is either IPFSNamespace, IPLDNamespace or empty. It only
+	// applies to paths with a Root and is left out of the string form of
+	// relative ones.
+	Namespace string
+
+	// Root is the CID the path starts from, undefined for relative paths.
+	Root cid.Cid
+
+	// Segments are the unescaped segments of the path.
+	Segments []string
+}
+
+// NewPath returns a path starting at root with the given segments.
+func NewPath(root cid.Cid, segments ...string) Path {
+	return Path{Root: root, Segments: segments}
+}
+
+// ParsePath parses a path in any of the following forms:
+//
+//	/ipfs/<cid>/a/b
+//	/ipld/<cid>/a/b
+//	/<cid>/a/b
+//	<cid>/a/b
+//	a/b
+//	./a/b
+//
+// A trailing slash is ignored, but empty segments are not allowed. Note a
+// path without a leading slash whose first segment is a valid CID is taken
+// as starting at that root unless it starts with "./"; use
+// ParseRelativePath to parse paths within a node whose segments may look
+// like CIDs.
+func ParsePath(s string) (Path, error) {
+	if strings.HasPrefix(s, relativePrefix) {
+		return ParseRelativePath(s)
+	}
+
+	rooted := strings.HasPrefix(s, "/")
+	segments, err := parseSegments(strings.TrimPrefix(s, "/"))
+	if err != nil {
+		return Path{}, fmt.Errorf("%w %q: %w", ErrInvalidPath, s, err)
+	}
+
+	var p Path
+	if rooted && len(segments) > 0 && (segments[0] == IPFSNamespace || segments[0] == IPLDNamespace) {
+		p.Namespace = segments[0]
+		segments = segments[1:]
+		if len(segments) == 0 {
+			return Path{}, fmt.Errorf("%w %q: missing root CID", ErrInvalidPath, s)
+		}
+	}
+	if len(segments) > 0 {
+		root, err := cid.Decode(segments[0])
+		switch {
+		case err == nil:
+			p.Root = root
+			segments = segments[1:]
+		case rooted:
+			return Path{}, fmt.Errorf("%w %q: invalid root CID: %w", ErrInvalidPath, s, err)
+		}
+	} else if rooted {
+		return Path{}, fmt.Errorf("%w %q: missing root CID", ErrInvalidPath, s)
+	}
+	p.Segments = segments
+	return p, nil
+}
+
+// ParseRelativePath parses a path without namespace nor root, like the
+// ones used inside a node (e.g., "a/b"). A leading "./" is ignored.
+func ParseRelativePath(s string) (Path, error) {
+	segments, err := parseSegments(strings.TrimPrefix(s, relativePrefix))
+	if err != nil {
+		return Path{}, fmt.Errorf("%w %q: %w", ErrInvalidPath, s, err)
+	}
+	return Path{Segments: segments}, nil
+}
+
+// parseSegments splits and unescapes the segments of a path.
+func parseSegments(s string) ([]string, error) {
+	s = strings.TrimSuffix(s, "/")
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, "/")
+	segments := make([]string, len(parts))
+	for i, part := range parts {
+		if part == "" {
+			return nil, errors.New("empty segment")
+		}
+		seg, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, err
+		}
+		segments[i] = seg
+	}
+	return segments, nil
+}
+
+// EscapeSegment escapes a path segment so it can be joined with others with
+// slashes. It is the inverse of the unescaping done by ParsePath.
+func EscapeSegment(seg string) string {
+	return strings.NewReplacer("%", "%25", "/", "%2F").Replace(seg)
+}
+
+// IsRelative returns whether the path has no root.
+func (p Path) IsRelative() bool {
+	return !p.Root.Defined()
+}
+
+// Join returns a new path with the given (unescaped) segments appended.
+func (p Path) Join(segments ...string) Path {
+	p.Segments = append(slices.Clip(p.Segments), segments...)
+	return p
+}
+
+// String returns the path in the form ParsePath accepts, escaping its
+// segments.
+func (p Path) String() string {
+	var b strings.Builder
+	if p.Root.Defined() {
+		if p.Namespace != "" {
+			b.WriteString("/" + p.Namespace)
+		}
+		b.WriteString("/" + p.Root.String())
+	} else if len(p.Segments) > 0 && isAmbiguousSegment(p.Segments[0]) {
+		b.WriteString(".")
+	}
+	for i, seg := range p.Segments {
+		if i > 0 || b.Len() > 0 {
+			b.WriteByte('/')
+		}
+		b.WriteString(EscapeSegment(seg))
+	}
+	return b.String()
+}
+
+// isAmbiguousSegment returns whether a relative path starting with the
+// segment seg needs a "./" prefix to be parsed back as relative.
+func isAmbiguousSegment(seg string) bool {
+	if seg == "." {
+		return true
+	}
+	_, err := cid.Decode(seg)
+	return err == nil
+}
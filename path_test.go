@@ -0,0 +1,128 @@
+package format
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	root := InitNode([]byte("root")).Cid()
+
+	for _, tc := range []struct {
+		in        string
+		namespace string
+		rooted    bool
+		segments  []string
+		out       string
+	}{
+		{"/ipfs/" + root.String() + "/a/b", IPFSNamespace, true, []string{"a", "b"}, ""},
+		{"/ipld/" + root.String(), IPLDNamespace, true, nil, ""},
+		{"/" + root.String() + "/a/", "", true, []string{"a"}, "/" + root.String() + "/a"},
+		{root.String() + "/a", "", true, []string{"a"}, "/" + root.String() + "/a"},
+		{"a/b%2Fc/100%25", "", false, []string{"a", "b/c", "100%"}, ""},
+		{"ipfs/a", "", false, []string{"ipfs", "a"}, ""},
+		{"./" + root.String() + "/a", "", false, []string{root.String(), "a"}, ""},
+		{"./a", "", false, []string{"a"}, "a"},
+		{"././a", "", false, []string{".", "a"}, ""},
+		{".", "", false, []string{"."}, "./."},
+		{"", "", false, nil, ""},
+	} {
+		p, err := ParsePath(tc.in)
+		if err != nil {
+			t.Fatalf("%q: %s", tc.in, err)
+		}
+		if p.Namespace != tc.namespace || p.IsRelative() == tc.rooted || !slices.Equal(p.Segments, tc.segments) {
+			t.Fatalf("%q: unexpected path %#v", tc.in, p)
+		}
+		if tc.rooted && !p.Root.Equals(root) {
+			t.Fatalf("%q: unexpected root %s", tc.in, p.Root)
+		}
+
+		want := tc.out
+		if want == "" {
+			want = tc.in
+		}
+		if p.String() != want {
+			t.Fatalf("%q: formatted as %q, expected %q", tc.in, p.String(), want)
+		}
+
+		// Round trip.
+		again, err := ParsePath(p.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.String() != p.String() || !slices.Equal(again.Segments, p.Segments) {
+			t.Fatalf("%q: round trip failed: %#v", tc.in, again)
+		}
+	}
+
+	for _, in := range []string{
+		"/ipfs",
+		"/ipfs/",
+		"/ipfs/notacid/a",
+		"/a/b",
+		"a//b",
+		"a/%zz",
+	} {
+		if _, err := ParsePath(in); !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("%q: expected ErrInvalidPath, got %v", in, err)
+		}
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	root := InitNode([]byte("root")).Cid()
+
+	p, err := ParseRelativePath(root.String() + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsRelative() || !slices.Equal(p.Segments, []string{root.String(), "a"}) {
+		t.Fatalf("unexpected path %#v", p)
+	}
+
+	joined := NewPath(root, "a").Join("b/c", "d")
+	if joined.String() != "/"+root.String()+"/a/b%2Fc/d" {
+		t.Fatalf("unexpected joined path %q", joined)
+	}
+	if !slices.Equal(joined.Segments, []string{"a", "b/c", "d"}) {
+		t.Fatalf("unexpected joined segments %v", joined.Segments)
+	}
+}
+
+func TestPathRoundTrip(t *testing.T) {
+	root := InitNode([]byte("root")).Cid()
+
+	for _, p := range []Path{
+		{Namespace: IPFSNamespace, Root: root, Segments: []string{"a", "b/c"}},
+		NewPath(root),
+		{Segments: []string{root.String(), "a"}},
+		{Segments: []string{root.String()}},
+		{Segments: []string{".", "..", "a"}},
+		{Segments: []string{"ipfs", root.String()}},
+		{Segments: []string{"100%", "/"}},
+		{},
+	} {
+		s := p.String()
+		parsed, err := ParsePath(s)
+		if err != nil {
+			t.Fatalf("%#v formatted as %q: %s", p, s, err)
+		}
+		if parsed.Namespace != p.Namespace || !parsed.Root.Equals(p.Root) || !slices.Equal(parsed.Segments, p.Segments) {
+			t.Fatalf("%#v formatted as %q parsed back as %#v", p, s, parsed)
+		}
+		if p.IsRelative() {
+			relative, err := ParseRelativePath(s)
+			if err != nil || !slices.Equal(relative.Segments, p.Segments) {
+				t.Fatalf("%q parsed back as relative %#v: %v", s, relative, err)
+			}
+		}
+	}
+
+	// The namespace only applies to rooted paths.
+	p := Path{Namespace: IPLDNamespace, Segments: []string{"a"}}
+	if p.String() != "a" {
+		t.Fatalf("expected the namespace of a relative path to be left out, got %q", p.String())
+	}
+}
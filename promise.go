@@ -2,65 +2,226 @@ package format
 
 import (
 	"context"
+	"iter"
+	"sync/atomic"
 )
 
-// NodePromise provides a promise like interface for a dag Node
-// the first call to Get will block until the Node is received
-// from its internal channels, subsequent calls will return the
-// cached node.
+// Promise provides a promise like interface for a value of type T: the
+// first call to Get will block until the value is received (or the
+// promise failed), subsequent calls will return the cached value.
 //
-// Thread Safety: This is multiple-consumer/single-producer safe.
-func NewNodePromise(ctx context.Context) *NodePromise {
-	return &NodePromise{
+// Thread Safety: This is multiple-consumer/multiple-producer safe. Only
+// the first call to Send, TrySend or Fail has any effect.
+type Promise[T any] struct {
+	value T
+	err   error
+	done  chan struct{}
+
+	// filled is set by the first producer to claim the promise, before
+	// writing the value and closing `done`.
+	filled atomic.Bool
+
+	ctx context.Context
+}
+
+// NewPromise creates a new Promise. If the given context is canceled before
+// the promise has been fulfilled, Get returns the context error.
+func NewPromise[T any](ctx context.Context) *Promise[T] {
+	return &Promise[T]{
 		done: make(chan struct{}),
 		ctx:  ctx,
 	}
 }
 
-type NodePromise struct {
-	value Node
-	err   error
-	done  chan struct{}
+// NodePromise provides a promise like interface for a dag Node
+// the first call to Get will block until the Node is received
+// from its internal channels, subsequent calls will return the
+// cached node.
+type NodePromise = Promise[Node]
 
-	ctx context.Context
+// NewNodePromise creates a new NodePromise.
+func NewNodePromise(ctx context.Context) *NodePromise {
+	return NewPromise[Node](ctx)
+}
+
+// settle fills the promise, returning false if it was already filled.
+func (p *Promise[T]) settle(value T, err error) bool {
+	if !p.filled.CompareAndSwap(false, true) {
+		return false
+	}
+	p.value = value
+	p.err = err
+	close(p.done)
+	return true
 }
 
 // Call this function to fail a promise.
 //
 // Once a promise has been failed or fulfilled, further attempts to fail it will
 // be silently dropped.
-func (np *NodePromise) Fail(err error) {
-	if np.err != nil || np.value != nil {
-		// Already filled.
-		return
-	}
-	np.err = err
-	close(np.done)
+func (p *Promise[T]) Fail(err error) {
+	var zero T
+	p.settle(zero, err)
 }
 
 // Fulfill this promise.
 //
 // Once a promise has been fulfilled or failed, calling this function will
-// panic.
-func (np *NodePromise) Send(nd Node) {
-	// if promise has a value, don't fail it
-	if np.err != nil || np.value != nil {
+// panic. Use TrySend when the promise may be filled concurrently.
+func (p *Promise[T]) Send(value T) {
+	if !p.TrySend(value) {
 		panic("already filled")
 	}
-	np.value = nd
-	close(np.done)
+}
+
+// TrySend fulfills this promise, returning false (and doing nothing) if it
+// has already been fulfilled or failed.
+func (p *Promise[T]) TrySend(value T) bool {
+	return p.settle(value, nil)
+}
+
+// Done returns a channel that is closed once the promise has been fulfilled
+// or failed. It is not closed when the promise context is canceled.
+func (p *Promise[T]) Done() <-chan struct{} {
+	return p.done
 }
 
 // Get the value of this promise.
 //
 // This function is safe to call concurrently from any number of goroutines.
-func (np *NodePromise) Get(ctx context.Context) (Node, error) {
+func (p *Promise[T]) Get(ctx context.Context) (T, error) {
+	var zero T
 	select {
-	case <-np.done:
-		return np.value, np.err
-	case <-np.ctx.Done():
-		return nil, np.ctx.Err()
+	case <-p.done:
+		return p.value, p.err
+	case <-p.ctx.Done():
+		return zero, p.ctx.Err()
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return zero, ctx.Err()
+	}
+}
+
+// PromiseResult is the outcome of one of the promises of a PromiseGroup.
+type PromiseResult[T any] struct {
+	// Index of the promise in the group.
+	Index int
+	Value T
+	Err   error
+}
+
+// PromiseGroup allows to wait on many promises at once, e.g., the ones
+// returned by GetNodes.
+type PromiseGroup[T any] struct {
+	promises []*Promise[T]
+}
+
+// NewPromiseGroup creates a PromiseGroup over the given promises.
+func NewPromiseGroup[T any](promises []*Promise[T]) *PromiseGroup[T] {
+	return &PromiseGroup[T]{promises: promises}
+}
+
+// NodePromiseGroup is a PromiseGroup of NodePromises.
+type NodePromiseGroup = PromiseGroup[Node]
+
+// NewNodePromiseGroup creates a NodePromiseGroup over the given promises.
+func NewNodePromiseGroup(promises []*NodePromise) *NodePromiseGroup {
+	return NewPromiseGroup(promises)
+}
+
+// Len returns the number of promises in the group.
+func (g *PromiseGroup[T]) Len() int {
+	return len(g.promises)
+}
+
+// WaitAll blocks until every promise in the group has been settled and
+// returns their values in order. The error returned is the one of the first
+// failed promise (in index order), the values of the other promises are
+// still filled in.
+func (g *PromiseGroup[T]) WaitAll(ctx context.Context) ([]T, error) {
+	values := make([]T, len(g.promises))
+	var firstErr error
+	for i, p := range g.promises {
+		v, err := p.Get(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return values, ctx.Err()
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		values[i] = v
+	}
+	return values, firstErr
+}
+
+// WaitAny blocks until any of the promises of the group has been settled
+// and returns its index alongside its outcome. It returns an index of -1
+// if the group is empty or the context is canceled first.
+func (g *PromiseGroup[T]) WaitAny(ctx context.Context) (int, T, error) {
+	for r := range g.Unordered(ctx) {
+		return r.Index, r.Value, r.Err
+	}
+	var zero T
+	return -1, zero, nil
+}
+
+// Ordered returns an iterator over the outcome of every promise of the
+// group in index order, blocking on each of them in turn.
+//
+// If the context is canceled the iteration ends after yielding a result
+// carrying the context error.
+func (g *PromiseGroup[T]) Ordered(ctx context.Context) iter.Seq[PromiseResult[T]] {
+	return func(yield func(PromiseResult[T]) bool) {
+		for i, p := range g.promises {
+			v, err := p.Get(ctx)
+			if !yield(PromiseResult[T]{Index: i, Value: v, Err: err}) {
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// Unordered returns an iterator over the outcome of every promise of the
+// group in the order they are settled, so results can be processed as they
+// arrive.
+//
+// If the context is canceled the iteration ends after yielding a result
+// carrying the context error (and an index of -1).
+func (g *PromiseGroup[T]) Unordered(ctx context.Context) iter.Seq[PromiseResult[T]] {
+	return func(yield func(PromiseResult[T]) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// Buffered so none of the watchers block once we return.
+		settled := make(chan int, len(g.promises))
+		for i, p := range g.promises {
+			go func() {
+				select {
+				case <-p.Done():
+				case <-p.ctx.Done():
+				case <-ctx.Done():
+					return
+				}
+				settled <- i
+			}()
+		}
+
+		for range g.promises {
+			select {
+			case i := <-settled:
+				v, err := g.promises[i].Get(ctx)
+				if !yield(PromiseResult[T]{Index: i, Value: v, Err: err}) {
+					return
+				}
+			case <-ctx.Done():
+				yield(PromiseResult[T]{Index: -1, Err: ctx.Err()})
+				return
+			}
+		}
 	}
 }
@@ -0,0 +1,124 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromiseSettleOnce(t *testing.T) {
+	ctx := context.Background()
+	p := NewNodePromise(ctx)
+
+	select {
+	case <-p.Done():
+		t.Fatal("promise should not be done yet")
+	default:
+	}
+
+	nd := new(EmptyNode)
+	if !p.TrySend(nd) {
+		t.Fatal("first TrySend should succeed")
+	}
+	if p.TrySend(nd) {
+		t.Fatal("second TrySend should fail")
+	}
+	p.Fail(errors.New("too late"))
+
+	<-p.Done()
+	v, err := p.Get(ctx)
+	if err != nil || v != nd {
+		t.Fatalf("unexpected promise value: %v, %v", v, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Send on a filled promise should panic")
+		}
+	}()
+	p.Send(nd)
+}
+
+func TestPromiseConcurrentProducers(t *testing.T) {
+	p := NewPromise[int](context.Background())
+	wins := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			if i%2 == 0 {
+				wins <- p.TrySend(i)
+			} else {
+				p.Fail(errors.New("failed"))
+				wins <- false
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-wins
+	}
+	<-p.Done()
+}
+
+func TestPromiseGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	promises := []*Promise[int]{
+		NewPromise[int](ctx),
+		NewPromise[int](ctx),
+		NewPromise[int](ctx),
+	}
+	g := NewPromiseGroup(promises)
+
+	errFailed := errors.New("failed")
+	promises[2].Send(2)
+	i, v, err := g.WaitAny(ctx)
+	if i != 2 || v != 2 || err != nil {
+		t.Fatalf("WaitAny returned (%d, %d, %v)", i, v, err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		promises[0].Send(0)
+		time.Sleep(10 * time.Millisecond)
+		promises[1].Fail(errFailed)
+	}()
+
+	var order []int
+	for r := range g.Unordered(ctx) {
+		order = append(order, r.Index)
+		if r.Index == 1 && r.Err != errFailed {
+			t.Fatalf("expected promise 1 to fail, got %v", r.Err)
+		}
+	}
+	if len(order) != 3 || order[0] != 2 || order[1] != 0 || order[2] != 1 {
+		t.Fatalf("unexpected completion order: %v", order)
+	}
+
+	order = order[:0]
+	for r := range g.Ordered(ctx) {
+		order = append(order, r.Index)
+	}
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Fatalf("unexpected iteration order: %v", order)
+	}
+
+	values, err := g.WaitAll(ctx)
+	if err != errFailed {
+		t.Fatalf("expected WaitAll to return the failure, got %v", err)
+	}
+	if values[0] != 0 || values[2] != 2 {
+		t.Fatalf("unexpected values: %v", values)
+	}
+}
+
+func TestPromiseGroupCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := NewNodePromiseGroup([]*NodePromise{NewNodePromise(context.Background())})
+	cancel()
+
+	i, _, err := g.WaitAny(ctx)
+	if i != -1 || err != context.Canceled {
+		t.Fatalf("expected the context error, got (%d, %v)", i, err)
+	}
+}
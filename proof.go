@@ -0,0 +1,97 @@
+package format
+
+import (
+	"context"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+)
+
+// ProvePath resolves a path from root like ResolvePath does and returns the
+// blocks of the nodes traversed, in order from the root. Those blocks are
+// the minimal proof that the path resolves to its value, which anyone can
+// check without trusting the NodeGetter using VerifyPath.
+//
+// Failures are returned as an ErrResolve.
+func ProvePath(ctx context.Context, ng NodeGetter, root cid.Cid, path []string) ([]blocks.Block, error) {
+	var proof []blocks.Block
+	_, err := resolvePath(ctx, ng, root, path, func(nd Node) {
+		proof = append(proof, nd)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// VerifyPath checks the proof returned by ProvePath for the given root and
+// path, and returns the value the path resolves to (see ResolvedPath).
+//
+// Every block is re-hashed against its CID, failing with an ErrHashMismatch
+// if the data doesn't match (or with the error of the hash function if it
+// isn't supported), and decoded from its raw data with the given Registry,
+// so nothing but the root CID needs to be trusted. Resolution
+// happens offline: a proof missing any of the traversed blocks fails with
+// an ErrResolve wrapping an ErrNotFound. Extra blocks are ignored.
+func VerifyPath(root cid.Cid, path []string, proof []blocks.Block, r *Registry) (interface{}, error) {
+	ng := make(proofGetter, len(proof))
+	for _, b := range proof {
+		c := b.Cid()
+		if err := checkHash(c, b.RawData()); err != nil {
+			return nil, err
+		}
+
+		// Decode from the raw data, never trusting the block to be a Node
+		// already, as Registry.Decode would.
+		raw, err := blocks.NewBlockWithCid(b.RawData(), c)
+		if err != nil {
+			return nil, err
+		}
+		nd, err := r.Decode(raw)
+		if err != nil {
+			return nil, err
+		}
+		ng[c.KeyString()] = nd
+	}
+
+	res, err := resolvePath(context.Background(), ng, root, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
+
+// checkHash re-hashes data with the prefix of the CID c, failing with an
+// ErrHashMismatch if it doesn't hash to c. Unlike comparing with the CID
+// reported by a Node (see checkNode), this catches corrupted data.
+func checkHash(c cid.Cid, data []byte) error {
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return err
+	}
+	if !sum.Equals(c) {
+		return ErrHashMismatch{Cid: c, Got: sum}
+	}
+	return nil
+}
+
+// proofGetter is an in-memory NodeGetter over the nodes of a proof.
+type proofGetter map[string]Node
+
+func (g proofGetter) Get(_ context.Context, c cid.Cid) (Node, error) {
+	nd, ok := g[c.KeyString()]
+	if !ok {
+		return nil, ErrNotFound{Cid: c}
+	}
+	return nd, nil
+}
+
+func (g proofGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *NodeOption {
+	out := make(chan *NodeOption, len(cids))
+	defer close(out)
+	for _, c := range cids {
+		nd, err := g.Get(ctx, c)
+		out <- &NodeOption{Node: nd, Err: err, Cid: c}
+	}
+	return out
+}
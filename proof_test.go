@@ -0,0 +1,91 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestProvePath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	leaf := newResolveNode("leaf")
+	mid := newResolveNode("mid", leaf)
+	other := newResolveNode("other")
+	root := newResolveNode("root", mid, other)
+	byData := make(map[string]Node)
+	for _, n := range []*resolveNode{leaf, mid, other, root} {
+		if err := ds.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+		byData[string(n.RawData())] = n
+	}
+
+	// TestNodes don't serialize their links, so decode by looking up the
+	// original node.
+	var reg Registry
+	reg.Register(cid.DagProtobuf, func(b blocks.Block) (Node, error) {
+		nd, ok := byData[string(b.RawData())]
+		if !ok {
+			return nil, errors.New("unknown node")
+		}
+		return nd, nil
+	})
+
+	path := []string{"mid", "leaf", "data"}
+	proof, err := ProvePath(ctx, ds, root.Cid(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof) != 3 {
+		t.Fatalf("expected 3 blocks in the proof, got %d", len(proof))
+	}
+	for i, want := range []Node{root, mid, leaf} {
+		if !proof[i].Cid().Equals(want.Cid()) {
+			t.Fatalf("unexpected block %d in the proof: %s", i, proof[i].Cid())
+		}
+	}
+
+	// Verify from plain blocks.
+	raw := make([]blocks.Block, len(proof))
+	for i, b := range proof {
+		raw[i], _ = blocks.NewBlockWithCid(b.RawData(), b.Cid())
+	}
+	val, err := VerifyPath(root.Cid(), path, raw, &reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val.([]byte)) != "leaf" {
+		t.Fatalf("unexpected value: %v", val)
+	}
+
+	// A proof missing a block.
+	_, err = VerifyPath(root.Cid(), path, raw[:2], &reg)
+	var resolveErr ErrResolve
+	if !errors.As(err, &resolveErr) || !IsNotFound(err) || resolveErr.Segment != 2 {
+		t.Fatalf("expected a not found resolution error at segment 2, got %v", err)
+	}
+
+	// A proof that doesn't cover the path.
+	_, err = VerifyPath(root.Cid(), []string{"other", "data"}, raw, &reg)
+	if !IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	// A tampered block.
+	tampered, _ := blocks.NewBlockWithCid([]byte("not leaf"), leaf.Cid())
+	_, err = VerifyPath(root.Cid(), path, []blocks.Block{raw[0], raw[1], tampered}, &reg)
+	if !errors.Is(err, ErrHashMismatch{}) {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+
+	_, err = ProvePath(ctx, ds, root.Cid(), []string{"mid", "nope"})
+	if !errors.Is(err, ErrPathNotFound{}) {
+		t.Fatalf("expected ErrPathNotFound, got %v", err)
+	}
+}
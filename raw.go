@@ -0,0 +1,113 @@
+package format
+
+import (
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// RawNode is a Node for raw blocks (cid.Raw): opaque data without links,
+// typically the leaves of a DAG.
+type RawNode struct {
+	blocks.Block
+}
+
+var _ Node = (*RawNode)(nil)
+
+// NewRawNode creates a RawNode for the given data, using a CIDv1 with the
+// raw codec and a sha2-256 multihash.
+func NewRawNode(data []byte) *RawNode {
+	nd, err := NewRawNodeWPrefix(data, cid.Prefix{
+		Version:  1,
+		Codec:    cid.Raw,
+		MhType:   mh.SHA2_256,
+		MhLength: -1,
+	})
+	if err != nil {
+		// sha2-256 can't fail to hash.
+		panic(err)
+	}
+	return nd
+}
+
+// NewRawNodeWPrefix creates a RawNode for the given data, with a CID built
+// by the given builder. The codec of the CID is always cid.Raw.
+func NewRawNodeWPrefix(data []byte, builder cid.Builder) (*RawNode, error) {
+	builder = builder.WithCodec(cid.Raw)
+	c, err := builder.Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	blk, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return nil, err
+	}
+	return &RawNode{blk}, nil
+}
+
+// DecodeRawBlock is a DecodeBlockFunc for raw blocks.
+func DecodeRawBlock(block blocks.Block) (Node, error) {
+	if block.Cid().Type() != cid.Raw {
+		return nil, fmt.Errorf("raw nodes cannot be decoded from codec 0x%x", block.Cid().Type())
+	}
+	return &RawNode{block}, nil
+}
+
+// RegisterRaw registers DecodeRawBlock in the given Registry for the
+// cid.Raw codec.
+func RegisterRaw(r *Registry) {
+	r.Register(cid.Raw, DecodeRawBlock)
+}
+
+// Resolve returns the data of the node for an empty path. Raw nodes have
+// no inner paths, so any other path fails with an ErrPathNotFound.
+func (n *RawNode) Resolve(path []string) (interface{}, []string, error) {
+	if len(path) > 0 {
+		return nil, nil, ErrPathNotFound{Cid: n.Cid(), Remaining: path}
+	}
+	return n.RawData(), nil, nil
+}
+
+// Tree returns nothing: raw nodes have no inner paths.
+func (n *RawNode) Tree(path string, depth int) []string {
+	return nil
+}
+
+// ResolveLink always fails with an ErrPathNotFound: raw nodes have no links.
+func (n *RawNode) ResolveLink(path []string) (*Link, []string, error) {
+	return nil, nil, ErrPathNotFound{Cid: n.Cid(), Remaining: path}
+}
+
+// Copy returns a copy of the node, with its own copy of the data.
+func (n *RawNode) Copy() Node {
+	data := append([]byte(nil), n.RawData()...)
+	blk, err := blocks.NewBlockWithCid(data, n.Cid())
+	if err != nil {
+		// The CID was already validated against the same data.
+		panic(err)
+	}
+	return &RawNode{blk}
+}
+
+// Links returns nil: raw nodes have no links.
+func (n *RawNode) Links() []*Link {
+	return nil
+}
+
+// Size returns the size of the data.
+func (n *RawNode) Size() (uint64, error) {
+	return uint64(len(n.RawData())), nil
+}
+
+// Stat returns stats about the node. All its data is in the data segment.
+func (n *RawNode) Stat() (*NodeStat, error) {
+	size := len(n.RawData())
+	return &NodeStat{
+		Hash:           n.Cid().String(),
+		BlockSize:      size,
+		DataSize:       size,
+		CumulativeSize: size,
+	}, nil
+}
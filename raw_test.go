@@ -0,0 +1,88 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestRawNode(t *testing.T) {
+	data := []byte("some raw data")
+	nd := NewRawNode(data)
+	if nd.Cid().Type() != cid.Raw || nd.Cid().Version() != 1 {
+		t.Fatalf("unexpected CID %s", nd.Cid())
+	}
+
+	val, rest, err := nd.Resolve(nil)
+	if err != nil || len(rest) != 0 || !bytes.Equal(val.([]byte), data) {
+		t.Fatalf("unexpected resolution: %v, %v, %v", val, rest, err)
+	}
+	if _, _, err := nd.Resolve([]string{"a"}); !errors.Is(err, ErrPathNotFound{}) {
+		t.Fatalf("expected ErrPathNotFound, got %v", err)
+	}
+	if _, _, err := nd.ResolveLink([]string{"a"}); !errors.Is(err, ErrPathNotFound{}) {
+		t.Fatalf("expected ErrPathNotFound, got %v", err)
+	}
+	if len(nd.Tree("", -1)) != 0 || len(nd.Links()) != 0 {
+		t.Fatal("raw nodes should have no paths nor links")
+	}
+
+	size, err := nd.Size()
+	if err != nil || size != uint64(len(data)) {
+		t.Fatalf("unexpected size %d: %v", size, err)
+	}
+	stat, err := nd.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Hash != nd.Cid().String() || stat.BlockSize != len(data) ||
+		stat.DataSize != len(data) || stat.CumulativeSize != len(data) || stat.NumLinks != 0 {
+		t.Fatalf("unexpected stat %s", stat)
+	}
+
+	cp := nd.Copy()
+	if !cp.Cid().Equals(nd.Cid()) || &cp.RawData()[0] == &nd.RawData()[0] {
+		t.Fatal("copy should have the same CID and its own data")
+	}
+
+	// Custom prefixes keep the raw codec.
+	prefixed, err := NewRawNodeWPrefix(data, cid.Prefix{
+		Version:  1,
+		Codec:    cid.DagProtobuf,
+		MhType:   mh.SHA2_512,
+		MhLength: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefixed.Cid().Type() != cid.Raw || prefixed.Cid().Prefix().MhType != mh.SHA2_512 {
+		t.Fatalf("unexpected CID %s", prefixed.Cid())
+	}
+}
+
+func TestRegisterRaw(t *testing.T) {
+	nd := NewRawNode([]byte("leaf"))
+	blk, err := blocks.NewBlockWithCid(nd.RawData(), nd.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reg Registry
+	RegisterRaw(&reg)
+	decoded, err := reg.Decode(blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.(*RawNode); !ok || !decoded.Cid().Equals(nd.Cid()) {
+		t.Fatalf("unexpected decoded node %v", decoded)
+	}
+
+	other, _ := blocks.NewBlockWithCid(nil, InitNode([]byte("x")).Cid())
+	if _, err := DecodeRawBlock(other); err == nil {
+		t.Fatal("expected non-raw blocks to fail decoding")
+	}
+}
@@ -0,0 +1,80 @@
+package format
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// ResolvedPath is the result of ResolvePath.
+type ResolvedPath struct {
+	// Value is the object the path resolves to. When the path ends at a
+	// link, it is the Node the link points to.
+	Value interface{}
+
+	// Node is the last node traversed, which holds Value (or is Value).
+	Node Node
+
+	// Cids are the CIDs of all the nodes traversed, from the root to Node.
+	// Those nodes are the proof that the path resolves to Value.
+	Cids []cid.Cid
+}
+
+// ResolvePath resolves a path starting at the root node, following links
+// across node boundaries (see Resolver.Resolve) by fetching the nodes they
+// point to from the NodeGetter.
+//
+// Failures are returned as an ErrResolve.
+func ResolvePath(ctx context.Context, ng NodeGetter, root cid.Cid, path []string) (*ResolvedPath, error) {
+	return resolvePath(ctx, ng, root, path, nil)
+}
+
+// resolvePath implements ResolvePath, calling visit (if not nil) with every
+// node traversed.
+func resolvePath(ctx context.Context, ng NodeGetter, root cid.Cid, path []string, visit func(Node)) (*ResolvedPath, error) {
+	res := new(ResolvedPath)
+	c, rest := root, path
+	for {
+		nd, err := ng.Get(ctx, c)
+		if err != nil {
+			return nil, ErrResolve{Path: path, Segment: len(path) - len(rest), Cause: err}
+		}
+		res.Node = nd
+		res.Cids = append(res.Cids, c)
+		if visit != nil {
+			visit(nd)
+		}
+
+		if len(rest) == 0 {
+			res.Value = nd
+			return res, nil
+		}
+
+		val, remaining, err := nd.Resolve(rest)
+		// Every step must consume part of the path.
+		if err == nil && len(remaining) >= len(rest) {
+			err = ErrPathNotFound{Cid: c, Remaining: rest}
+		}
+		if err != nil {
+			return nil, ErrResolve{Path: path, Segment: len(path) - len(rest), Cause: err}
+		}
+
+		switch v := val.(type) {
+		case *Link:
+			c = v.Cid
+		case cid.Cid:
+			c = v
+		default:
+			if len(remaining) > 0 {
+				return nil, ErrResolve{
+					Path:    path,
+					Segment: len(path) - len(remaining),
+					Cause:   ErrPathNotFound{Cid: c, Remaining: remaining},
+				}
+			}
+			res.Value = val
+			return res, nil
+		}
+		rest = remaining
+	}
+}
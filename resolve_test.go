@@ -0,0 +1,134 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// resolveNode is a TestNode that resolves the names of its links, and the
+// "data" path to its data.
+type resolveNode struct {
+	*TestNode
+}
+
+func newResolveNode(data string, links ...Node) *resolveNode {
+	n := &resolveNode{InitNode([]byte(data))}
+	for _, l := range links {
+		n.AddNodeLink(l.String(), l)
+	}
+	return n
+}
+
+func (n *resolveNode) Resolve(path []string) (interface{}, []string, error) {
+	if path[0] == "data" {
+		return n.data, path[1:], nil
+	}
+	for _, l := range n.links {
+		if l.Name == path[0] {
+			return l, path[1:], nil
+		}
+	}
+	return nil, nil, ErrPathNotFound{Cid: n.Cid(), Remaining: path}
+}
+
+func TestResolvePath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	leaf := newResolveNode("leaf")
+	mid := newResolveNode("mid", leaf)
+	root := newResolveNode("root", mid)
+	for _, n := range []Node{leaf, mid, root} {
+		if err := ds.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := ResolvePath(ctx, ds, root.Cid(), []string{"mid", "leaf", "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res.Value.([]byte)) != "leaf" || res.Node.Cid() != leaf.Cid() {
+		t.Fatalf("unexpected resolution: %v in %s", res.Value, res.Node.Cid())
+	}
+	wantCids := []cid.Cid{root.Cid(), mid.Cid(), leaf.Cid()}
+	if len(res.Cids) != len(wantCids) {
+		t.Fatalf("expected %d traversed CIDs, got %d", len(wantCids), len(res.Cids))
+	}
+	for i := range wantCids {
+		if !res.Cids[i].Equals(wantCids[i]) {
+			t.Fatalf("unexpected traversed CID %d: %s", i, res.Cids[i])
+		}
+	}
+
+	// Ending at a link yields the linked node.
+	res, err = ResolvePath(ctx, ds, root.Cid(), []string{"mid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Value.(Node).Cid() != mid.Cid() {
+		t.Fatalf("expected to resolve to the linked node, got %v", res.Value)
+	}
+
+	_, err = ResolvePath(ctx, ds, root.Cid(), []string{"mid", "nope", "data"})
+	var resolveErr ErrResolve
+	if !errors.As(err, &resolveErr) || resolveErr.Segment != 1 {
+		t.Fatalf("expected an error at segment 1, got %v", err)
+	}
+	var notFound ErrPathNotFound
+	if !errors.As(err, &notFound) || notFound.Cid != mid.Cid() || len(notFound.Remaining) != 2 {
+		t.Fatalf("expected ErrPathNotFound in the middle node, got %v", err)
+	}
+
+	if err := ds.Remove(ctx, leaf.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ResolvePath(ctx, ds, root.Cid(), []string{"mid", "leaf", "data"})
+	if !errors.As(err, &resolveErr) || resolveErr.Segment != 2 || !IsNotFound(err) {
+		t.Fatalf("expected ErrNotFound at segment 2, got %v", err)
+	}
+}
+
+// brokenNode is a TestNode failing to resolve any path with err, or not
+// consuming it if err is nil.
+type brokenNode struct {
+	*TestNode
+	err error
+}
+
+func (n *brokenNode) Resolve(path []string) (interface{}, []string, error) {
+	return nil, path, n.err
+}
+
+func TestResolvePathErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	errBroken := ErrDecode{Cause: errors.New("truncated")}
+	broken := &brokenNode{InitNode([]byte("broken")), errBroken}
+	if err := ds.Add(ctx, broken); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ResolvePath(ctx, ds, broken.Cid(), []string{"a"})
+	if !errors.Is(err, ErrResolve{}) || !errors.Is(err, errBroken) {
+		t.Fatalf("expected an ErrResolve wrapping the node error, got %v", err)
+	}
+	if errors.Is(err, ErrPathNotFound{}) {
+		t.Fatalf("a failure to resolve is not a missing path: %v", err)
+	}
+
+	// A node that doesn't consume the path is a clean miss.
+	stuck := &brokenNode{InitNode([]byte("stuck")), nil}
+	if err := ds.Add(ctx, stuck); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ResolvePath(ctx, ds, stuck.Cid(), []string{"a"})
+	if !errors.Is(err, ErrResolve{}) || !errors.Is(err, ErrPathNotFound{}) {
+		t.Fatalf("expected an ErrResolve wrapping ErrPathNotFound, got %v", err)
+	}
+}
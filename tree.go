@@ -0,0 +1,259 @@
+package format
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// TreeEntry is a path listed by TreeDAG, or the error that ended the listing.
+type TreeEntry struct {
+	// Path is the path of the entry from the root of the DAG: the names of
+	// the links followed and the path within the last node (as returned by
+	// its Tree method), joined by slashes.
+	Path string
+
+	// Cid is the CID of the node the entry belongs to.
+	Cid cid.Cid
+
+	Err error
+}
+
+// TreeDAG lists all paths in the DAG under root up to the given depth,
+// counted in path segments (pass -1 for no limit), like Resolver.Tree does
+// for a single node. Nodes are fetched from the NodeGetter as their links
+// are followed and their paths are prefixed with the names of those links.
+//
+// When filters are given, only the paths matching at least one of them (see
+// path.Match) are listed. As patterns never match across slashes, they also
+// prune the traversal: links are only followed when the path they lead to
+// matches the leading segments of a longer filter.
+//
+// Children are fetched as they are descended into, prefetching up to
+// treePrefetchSize of them ahead.
+//
+// The entries are streamed, depth first, on the returned channel, which is
+// closed once the listing is done. A failure (including ErrCycleDetected if
+// a node links back to one of its ancestors) is sent as a last entry with
+// the Err field set. Canceling the context stops the listing.
+func TreeDAG(ctx context.Context, ng NodeGetter, root cid.Cid, depth int, filters ...string) <-chan *TreeEntry {
+	out := make(chan *TreeEntry)
+	go func() {
+		defer close(out)
+
+		t := &treeLister{
+			ng:      ng,
+			out:     out,
+			depth:   depth,
+			filters: filters,
+			limits:  newTraversalLimits(nil),
+		}
+		err := t.checkFilters()
+		if err == nil {
+			err = t.list(ctx, root, nil, "")
+		}
+		if err != nil && ctx.Err() == nil {
+			select {
+			case out <- &TreeEntry{Cid: root, Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out
+}
+
+// Number of children TreeDAG prefetches ahead of the one being listed.
+const treePrefetchSize = 10
+
+type treeLister struct {
+	ng      NodeGetter
+	out     chan<- *TreeEntry
+	depth   int
+	filters []string
+	limits  *traversalLimits
+
+	// The segments of each of the filters.
+	patterns [][]string
+
+	// CIDs of the nodes from the root to the one being listed.
+	ancestors []cid.Cid
+}
+
+// checkFilters validates the filters and caps the depth to the number of
+// segments of the longest of them.
+func (t *treeLister) checkFilters() error {
+	if len(t.filters) == 0 {
+		return nil
+	}
+	longest := 0
+	for _, f := range t.filters {
+		if _, err := path.Match(f, ""); err != nil {
+			return err
+		}
+		longest = max(longest, segmentCount(f))
+		t.patterns = append(t.patterns, strings.Split(f, "/"))
+	}
+	if t.depth < 0 || t.depth > longest {
+		t.depth = longest
+	}
+	return nil
+}
+
+// list lists the paths of node nd (or the one with CID c, if nd is nil),
+// found under prefix, and then descends into its links.
+func (t *treeLister) list(ctx context.Context, c cid.Cid, nd Node, prefix string) error {
+	err := t.limits.enter(len(t.ancestors), c, slices.Values(t.ancestors))
+	if err != nil {
+		return err
+	}
+	if nd == nil {
+		nd, err = t.ng.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	used := segmentCount(prefix)
+	remaining := -1
+	if t.depth >= 0 {
+		remaining = t.depth - used
+	}
+
+	listed := make(map[string]struct{})
+	for _, p := range nd.Tree("", remaining) {
+		listed[p] = struct{}{}
+		if err := t.emit(ctx, c, joinTreePath(prefix, p)); err != nil {
+			return err
+		}
+	}
+
+	if remaining == 0 {
+		return nil
+	}
+	links := nd.Links()
+	if len(links) == 0 {
+		return nil
+	}
+
+	// Links are reachable paths too, even when the node doesn't list them.
+	for _, l := range links {
+		if _, ok := listed[l.Name]; ok {
+			continue
+		}
+		if remaining >= 0 && segmentCount(l.Name) > remaining {
+			continue
+		}
+		if err := t.emit(ctx, c, joinTreePath(prefix, l.Name)); err != nil {
+			return err
+		}
+	}
+
+	// Only descend through links leaving room for more segments, and
+	// under which the filters may match.
+	var children []*Link
+	for _, l := range links {
+		if (t.depth < 0 || used+segmentCount(l.Name) < t.depth) && t.mayMatchUnder(joinTreePath(prefix, l.Name)) {
+			children = append(children, l)
+		}
+	}
+	if len(children) == 0 {
+		return nil
+	}
+
+	keys := make([]cid.Cid, len(children))
+	for i, l := range children {
+		keys[i] = l.Cid
+	}
+	// Prefetch the next children while we descend into them in order,
+	// topping the window up once half of it was consumed.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	promises := make([]*NodePromise, 0, len(children))
+
+	t.ancestors = append(t.ancestors, c)
+	for i, l := range children {
+		if len(promises)-i <= treePrefetchSize/2 {
+			end := min(i+treePrefetchSize, len(children))
+			promises = append(promises, GetNodes(ctx, t.ng, keys[len(promises):end])...)
+		}
+		child, err := promises[i].Get(ctx)
+		promises[i] = nil
+		if err != nil {
+			return err
+		}
+		err = t.list(ctx, l.Cid, child, joinTreePath(prefix, l.Name))
+		if err != nil {
+			return err
+		}
+	}
+	t.ancestors = t.ancestors[:len(t.ancestors)-1]
+	return nil
+}
+
+// emit sends the given path if it matches the filters.
+func (t *treeLister) emit(ctx context.Context, c cid.Cid, p string) error {
+	if !t.matches(p) {
+		return nil
+	}
+	select {
+	case t.out <- &TreeEntry{Path: p, Cid: c}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *treeLister) matches(p string) bool {
+	if len(t.filters) == 0 {
+		return true
+	}
+	for _, f := range t.filters {
+		// Patterns were validated upfront.
+		if ok, _ := path.Match(f, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatchUnder returns whether the filters may match paths under prefix:
+// whether a filter with more segments matches its leading ones.
+func (t *treeLister) mayMatchUnder(prefix string) bool {
+	if len(t.patterns) == 0 {
+		return true
+	}
+	segments := strings.Split(prefix, "/")
+	for _, pattern := range t.patterns {
+		if len(pattern) > len(segments) && matchSegments(pattern[:len(segments)], segments) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(patterns, segments []string) bool {
+	for i, p := range patterns {
+		// Patterns were validated upfront.
+		if ok, _ := path.Match(p, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func joinTreePath(prefix, p string) string {
+	if prefix == "" {
+		return p
+	}
+	return prefix + "/" + p
+}
+
+func segmentCount(p string) int {
+	if p == "" {
+		return 0
+	}
+	return strings.Count(p, "/") + 1
+}
@@ -0,0 +1,191 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// treeNode is a TestNode listing a "data" path besides its links.
+type treeNode struct {
+	*TestNode
+}
+
+func (n *treeNode) Tree(path string, depth int) []string {
+	if depth == 0 {
+		return nil
+	}
+	return []string{"data"}
+}
+
+func collectTree(t *testing.T, ch <-chan *TreeEntry) ([]string, error) {
+	t.Helper()
+	var paths []string
+	for e := range ch {
+		if e.Err != nil {
+			return paths, e.Err
+		}
+		paths = append(paths, e.Path)
+	}
+	return paths, nil
+}
+
+func TestTreeDAG(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	leaf := &treeNode{InitNode([]byte("leaf"))}
+	other := &treeNode{InitNode([]byte("other"))}
+	mid := &treeNode{InitNode([]byte("mid"))}
+	mid.AddNodeLink("c", leaf)
+	root := &treeNode{InitNode([]byte("root"))}
+	root.AddNodeLink("a", mid)
+	root.AddNodeLink("b", other)
+	for _, n := range []Node{leaf, other, mid, root} {
+		if err := ds.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		depth   int
+		filters []string
+		want    []string
+	}{
+		{-1, nil, []string{"data", "a", "b", "a/data", "a/c", "a/c/data", "b/data"}},
+		{1, nil, []string{"data", "a", "b"}},
+		{2, nil, []string{"data", "a", "b", "a/data", "a/c", "b/data"}},
+		{-1, []string{"*/data"}, []string{"a/data", "b/data"}},
+		{-1, []string{"a/*", "a/c/*"}, []string{"a/data", "a/c", "a/c/data"}},
+		{1, []string{"*/data"}, nil},
+	} {
+		paths, err := collectTree(t, TreeDAG(ctx, ds, root.Cid(), tc.depth, tc.filters...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(paths, tc.want) {
+			t.Fatalf("depth %d, filters %v: expected %v, got %v", tc.depth, tc.filters, tc.want, paths)
+		}
+	}
+
+	_, err := collectTree(t, TreeDAG(ctx, ds, root.Cid(), -1, "[a"))
+	if err == nil {
+		t.Fatal("expected an error for an invalid filter")
+	}
+}
+
+func TestTreeDAGCycle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	// TestNodes are hashed without their links, so they can form a cycle.
+	a := &treeNode{InitNode([]byte("a"))}
+	b := &treeNode{InitNode([]byte("b"))}
+	a.AddNodeLink("b", b)
+	b.AddNodeLink("a", a)
+	ds.Add(ctx, a)
+	ds.Add(ctx, b)
+
+	_, err := collectTree(t, TreeDAG(ctx, ds, a.Cid(), -1))
+	if !errors.Is(err, ErrCycleDetected{}) {
+		t.Fatalf("expected ErrCycleDetected, got %v", err)
+	}
+}
+
+func TestTreeDAGCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ds := newTestDag()
+
+	root := &treeNode{InitNode([]byte("root"))}
+	for _, name := range []string{"a", "b", "c"} {
+		child := &treeNode{InitNode([]byte(name))}
+		root.AddNodeLink(name, child)
+		ds.Add(ctx, child)
+	}
+	ds.Add(ctx, root)
+
+	ch := TreeDAG(ctx, ds, root.Cid(), -1)
+	<-ch
+	cancel()
+	for range ch {
+	}
+}
+
+// recordingGetter is a NodeGetter recording the keys requested from it.
+type recordingGetter struct {
+	NodeGetter
+
+	mu        sync.Mutex
+	requested []cid.Cid
+	maxBatch  int
+}
+
+func (g *recordingGetter) Get(ctx context.Context, c cid.Cid) (Node, error) {
+	g.record([]cid.Cid{c})
+	return g.NodeGetter.Get(ctx, c)
+}
+
+func (g *recordingGetter) GetMany(ctx context.Context, cids []cid.Cid) <-chan *NodeOption {
+	g.record(cids)
+	return g.NodeGetter.GetMany(ctx, cids)
+}
+
+func (g *recordingGetter) record(cids []cid.Cid) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requested = append(g.requested, cids...)
+	g.maxBatch = max(g.maxBatch, len(cids))
+}
+
+func TestTreeDAGFetching(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	wide := &treeNode{InitNode([]byte("wide"))}
+	for i := 0; i < 100; i++ {
+		child := &treeNode{InitNode([]byte(fmt.Sprint(i)))}
+		wide.AddNodeLink(fmt.Sprint(i), child)
+		ds.Add(ctx, child)
+	}
+	other := &treeNode{InitNode([]byte("other"))}
+	root := &treeNode{InitNode([]byte("root"))}
+	root.AddNodeLink("a", wide)
+	root.AddNodeLink("b", other)
+	ds.Add(ctx, wide)
+	ds.Add(ctx, other)
+	ds.Add(ctx, root)
+
+	// Children are fetched in a bounded window.
+	g := &recordingGetter{NodeGetter: ds}
+	paths, err := collectTree(t, TreeDAG(ctx, g, root.Cid(), -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3+1+2*100+1 {
+		t.Fatalf("unexpected number of paths: %d", len(paths))
+	}
+	if g.maxBatch > treePrefetchSize {
+		t.Fatalf("expected at most %d children fetched at once, got %d", treePrefetchSize, g.maxBatch)
+	}
+
+	// Filters prune the links that can't lead to a match.
+	g = &recordingGetter{NodeGetter: ds}
+	paths, err = collectTree(t, TreeDAG(ctx, g, root.Cid(), -1, "b/*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(paths, []string{"b/data"}) {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+	if slices.ContainsFunc(g.requested, wide.Cid().Equals) {
+		t.Fatal("expected the link not matching the filter to not be followed")
+	}
+}
@@ -3,6 +3,8 @@ package format
 import (
 	"context"
 	"errors"
+
+	cid "github.com/ipfs/go-cid"
 )
 
 // Walker provides methods to move through a DAG of nodes that implement
@@ -67,6 +69,10 @@ type Walker struct {
 	// the `NavigableIPLDNode` which context should be used to load node
 	// promises (but this could later be used in more elaborate ways).
 	ctx context.Context
+
+	// Safety bounds of the walk operations (see `TraversalOption`). Every
+	// node visited through `down` is accounted for in it.
+	limits *traversalLimits
 }
 
 // `Walker` implementation details:
@@ -137,9 +143,14 @@ type NavigableNode interface {
 
 // NewWalker creates a new `Walker` structure from a `root`
 // NavigableNode.
-func NewWalker(ctx context.Context, root NavigableNode) *Walker {
+//
+// Moving down to a node that is also one of its ancestors fails with
+// `ErrCycleDetected`; `TraversalOption`s can be passed to further bound
+// the depth and number of nodes visited.
+func NewWalker(ctx context.Context, root NavigableNode, opts ...TraversalOption) *Walker {
 	return &Walker{
-		ctx: ctx,
+		ctx:    ctx,
+		limits: newTraversalLimits(opts),
 
 		path:       []NavigableNode{root},
 		childIndex: []uint{0},
@@ -306,6 +317,11 @@ func (w *Walker) down(visitor Visitor) error {
 		return err
 	}
 
+	err = w.checkLimits(child)
+	if err != nil {
+		return err
+	}
+
 	w.extendPath(child)
 
 	return w.visitActiveNode(visitor)
@@ -333,6 +349,30 @@ func (w *Walker) fetchChild() (NavigableNode, error) {
 	// from `down`.
 }
 
+// Check that moving down to `child` doesn't break the `limits` of the
+// `Walker` nor closes a cycle in the active `path`. Nodes that don't wrap
+// an IPLD `Node` are exempt from the cycle detection.
+func (w *Walker) checkLimits(child NavigableNode) error {
+	var c cid.Cid
+	if nd := child.GetIPLDNode(); nd != nil {
+		c = nd.Cid()
+	}
+
+	ancestors := func(yield func(cid.Cid) bool) {
+		if !c.Defined() {
+			return
+		}
+		for _, n := range w.path[:w.currentDepth+1] {
+			nd := n.GetIPLDNode()
+			if nd != nil && !yield(nd.Cid()) {
+				return
+			}
+		}
+	}
+
+	return w.limits.enter(w.currentDepth+1, c, ancestors)
+}
+
 // Increase the `currentDepth` and extend the `path` to the fetched
 // `child` node (which now becomes the new `ActiveNode`)
 func (w *Walker) extendPath(child NavigableNode) {
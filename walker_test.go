@@ -0,0 +1,55 @@
+package format
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWalkerLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ds := newTestDag()
+
+	root := InitNode([]byte("root"))
+	a := InitNode([]byte("a"))
+	b := InitNode([]byte("b"))
+	c := InitNode([]byte("c"))
+	a.AddNodeLink("c", c)
+	root.AddNodeLink("a", a)
+	root.AddNodeLink("b", b)
+	for _, n := range []Node{root, a, b, c} {
+		if err := ds.Add(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iterate := func(opts ...TraversalOption) (int, error) {
+		visited := 0
+		w := NewWalker(ctx, NewNavigableIPLDNode(root, ds), opts...)
+		err := w.Iterate(func(NavigableNode) error {
+			visited++
+			return nil
+		})
+		if err == EndOfDag {
+			err = nil
+		}
+		return visited, err
+	}
+
+	if n, err := iterate(); err != nil || n != 4 {
+		t.Fatalf("expected to visit 4 nodes, visited %d (err: %v)", n, err)
+	}
+	if _, err := iterate(MaxDepthTraversalOption(1)); !errors.Is(err, ErrMaxDepthExceeded{}) {
+		t.Fatalf("expected ErrMaxDepthExceeded, got %v", err)
+	}
+	if n, err := iterate(MaxNodesTraversalOption(2)); !errors.Is(err, ErrMaxNodesExceeded{}) || n != 2 {
+		t.Fatalf("expected ErrMaxNodesExceeded after 2 nodes, visited %d (err: %v)", n, err)
+	}
+
+	// Make `c` link back to the root.
+	c.AddNodeLink("root", root)
+	if _, err := iterate(); !errors.Is(err, ErrCycleDetected{}) {
+		t.Fatalf("expected ErrCycleDetected, got %v", err)
+	}
+}